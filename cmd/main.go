@@ -8,12 +8,20 @@ import (
 	"roob.re/wallabot/telegram"
 	"strconv"
 	"strings"
+	"time"
+	_ "time/tzdata" // Embed timezone database, as it is not present in the runtime image
 )
 
 func main() {
 	token := flag.String("token", os.Getenv("WB_TOKEN"), "Telegram bot token")
 	metricsAddr := flag.String("metrics-addr", os.Getenv("WB_METRICS_ADDR"), "Listen address for metrics server")
 	vipUsers := flag.String("vips", os.Getenv("WB_VIPS"), "Comma-separated list of VIP usernames")
+	proxies := flag.String("proxies", os.Getenv("WB_PROXIES"), "Comma-separated list of http:// or socks5:// proxies to reach Wallapop through")
+	admins := flag.String("admins", os.Getenv("WB_ADMINS"), "Comma-separated list of usernames told when Wallapop blocks the bot")
+	qps := flag.Float64("qps", func() float64 {
+		f, _ := strconv.ParseFloat(os.Getenv("WB_QPS"), 64)
+		return f
+	}(), "Requests per second made to Wallapop at most, 0 for the default")
 	dbpath := flag.String("dbpath", func() string {
 		env := os.Getenv("WB_DBPATH")
 		if env == "" {
@@ -25,6 +33,32 @@ func main() {
 		b, _ := strconv.ParseBool(os.Getenv("WB_VERBOSE"))
 		return b
 	}(), "Be verbose")
+	minInterval := flag.Duration("min-interval", func() time.Duration {
+		d, _ := time.ParseDuration(os.Getenv("WB_MIN_INTERVAL"))
+		return d
+	}(), "Shortest interval searches adapt to, 0 for the default")
+	maxInterval := flag.Duration("max-interval", func() time.Duration {
+		d, _ := time.ParseDuration(os.Getenv("WB_MAX_INTERVAL"))
+		return d
+	}(), "Longest interval searches adapt to, 0 for the default")
+	cacheTTL := flag.Duration("cache-ttl", func() time.Duration {
+		d, _ := time.ParseDuration(os.Getenv("WB_CACHE_TTL"))
+		return d
+	}(), "How long Wallapop search pages are cached, 0 to disable caching")
+	cacheSize := flag.Int("cache-size", func() int {
+		n, err := strconv.Atoi(os.Getenv("WB_CACHE_SIZE"))
+		if err != nil {
+			return 512
+		}
+		return n
+	}(), "Maximum number of Wallapop search pages cached, 0 to disable caching")
+	sentRetention := flag.Duration("sent-retention", func() time.Duration {
+		d, err := time.ParseDuration(os.Getenv("WB_SENT_RETENTION"))
+		if err != nil {
+			return 90 * 24 * time.Hour
+		}
+		return d
+	}(), "How long notified items are remembered after they stop showing up in results, 0 to remember them forever")
 	flag.Parse()
 
 	var vipUserList []string
@@ -35,13 +69,37 @@ func main() {
 		}
 	}
 
+	var proxyList []string
+	for _, proxy := range strings.Split(*proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if len(proxy) > 0 {
+			proxyList = append(proxyList, proxy)
+		}
+	}
+
+	var adminList []string
+	for _, admin := range strings.Split(*admins, ",") {
+		admin = strings.TrimSpace(admin)
+		if len(admin) > 0 {
+			adminList = append(adminList, admin)
+		}
+	}
+
 	wb, err := wallabot.New(wallabot.Config{
 		DBPath:               *dbpath,
 		Token:                *token,
 		MetricsListenAddress: *metricsAddr,
+		SearchMinInterval:    *minInterval,
+		SearchMaxInterval:    *maxInterval,
+		WallapopQPS:          *qps,
+		CacheTTL:             *cacheTTL,
+		CacheSize:            *cacheSize,
+		Proxies:              proxyList,
+		SentRetention:        *sentRetention,
 		WallabotConfig: telegram.WallabotConfig{
 			Verbose:  *verbose,
 			VIPUsers: vipUserList,
+			Admins:   adminList,
 		},
 	})
 
@@ -24,6 +24,7 @@ func New(path string) (*Database, error) {
 	}, nil
 }
 
+// User calls f with a user, without the items they have been notified about. Use UserWithSent or LoadSent for those.
 func (db *Database) User(id int, f func(u *User) error) error {
 	idb := userKey(id)
 	return db.bdg.View(func(txn *badger.Txn) error {
@@ -40,6 +41,8 @@ func (db *Database) User(id int, f func(u *User) error) error {
 	})
 }
 
+// UserUpdate calls f with a user and stores it afterwards. Items they have been notified about are neither loaded nor
+// written, use UserUpdateWithSent to change those.
 func (db *Database) UserUpdate(id int, f func(u *User) error) error {
 	idb := userKey(id)
 	return db.bdg.Update(func(txn *badger.Txn) error {
@@ -57,6 +60,7 @@ func (db *Database) UserUpdate(id int, f func(u *User) error) error {
 	})
 }
 
+// UserEach calls f with every user, without the items they have been notified about
 func (db *Database) UserEach(f func(u *User) error) error {
 	return db.bdg.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.IteratorOptions{
@@ -84,6 +88,59 @@ func (db *Database) UserEach(f func(u *User) error) error {
 	})
 }
 
+// UserWithSent is like User, but also fills the items the user and their searches have been notified about
+func (db *Database) UserWithSent(id int, f func(u *User) error) error {
+	return db.bdg.View(func(txn *badger.Txn) error {
+		user, err := db.getUser(userKey(id), txn.Get)
+		if err != nil {
+			return err
+		}
+
+		err = db.loadSent(txn, user)
+		if err != nil {
+			return err
+		}
+
+		err = f(user)
+		if err != nil {
+			return fmt.Errorf("user function: %w", err)
+		}
+		return nil
+	})
+}
+
+// UserUpdateWithSent is like UserUpdate, but also fills the items the user and their searches have been notified
+// about, and writes back the ones that changed, including forgetting those of deleted searches
+func (db *Database) UserUpdateWithSent(id int, f func(u *User) error) error {
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		user, err := db.getUser(userKey(id), txn.Get)
+		if err != nil {
+			return err
+		}
+
+		err = db.loadSent(txn, user)
+		if err != nil {
+			return err
+		}
+		trackSent(user)
+
+		err = f(user)
+		if err != nil {
+			return fmt.Errorf("user function: %w", err)
+		}
+
+		return db.putUser(user, txn)
+	})
+}
+
+// LoadSent fills the items a user read without them, and their searches, have been notified about. Changes to them
+// are not written if the user is stored afterwards.
+func (db *Database) LoadSent(user *User) error {
+	return db.bdg.View(func(txn *badger.Txn) error {
+		return db.loadSent(txn, user)
+	})
+}
+
 func (db *Database) getUser(idb []byte, getter func([]byte) (*badger.Item, error)) (*User, error) {
 	user := &User{}
 	item, err := getter(idb)
@@ -113,7 +170,17 @@ func (db *Database) putUser(user *User, txn *badger.Txn) error {
 		return fmt.Errorf("marshalling user into json: %w", err)
 	}
 
-	return txn.Set(idb, userJson)
+	err = txn.Set(idb, userJson)
+	if err != nil {
+		return err
+	}
+
+	// Sent items are only written if they were loaded to be changed
+	if user.sent == nil {
+		return nil
+	}
+
+	return db.saveSent(txn, user)
 }
 
 func (db *Database) AssertUser(u *User) error {
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"roob.re/wallabot/wallapop"
+)
+
+const (
+	historyKeyPrefix = "history_"
+	pricesKeyPrefix  = "prices_"
+	slugKeyPrefix    = "slug_"
+)
+
+const (
+	// Price observations, and the summary and slug of items, are dropped after this long without the item being seen
+	historyTTL = 180 * 24 * time.Hour
+	// Items seen again at the same price have their last seen time updated at most this often
+	historySeenResolution = time.Hour
+	// Items seen again have the TTL of their history extended at most this often
+	historyRefresh = 7 * 24 * time.Hour
+)
+
+// PricePoint is an observation of the price of an item at a given time
+type PricePoint struct {
+	Time  time.Time
+	Price float64
+}
+
+// priceSummary is kept for each item next to its price history, so it does not need to be scanned
+type priceSummary struct {
+	Last      float64
+	Lowest    float64
+	Points    int
+	Seen      time.Time // Last time the item was found in results, whatever its price
+	Refreshed time.Time // Last time the TTL of the history of the item was extended
+}
+
+// RecordPrices stores the current price of the given items as a new observation in their price history, unless it is
+// the same as the last one recorded, and records that the items have been seen
+func (db *Database) RecordPrices(items []wallapop.Item, t time.Time) error {
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		for _, item := range items {
+			summary := priceSummary{}
+			found := true
+
+			stored, err := txn.Get(pricesKey(item.ID))
+			switch {
+			case errors.Is(err, badger.ErrKeyNotFound):
+				// Items seen by older versions only have their history
+				summary, found, err = summarize(txn, item.ID)
+				if err != nil {
+					return err
+				}
+			case err != nil:
+				return fmt.Errorf("getting price summary from DB: %w", err)
+			default:
+				err = stored.Value(func(val []byte) error {
+					return json.Unmarshal(val, &summary)
+				})
+				if err != nil {
+					return fmt.Errorf("unmarshalling price summary from DB: %w", err)
+				}
+			}
+
+			changed := !found || summary.Last != item.Price
+			refresh := t.Sub(summary.Refreshed) >= historyRefresh
+			if !changed && !refresh && t.Sub(summary.Seen) < historySeenResolution {
+				continue
+			}
+
+			var entries []*badger.Entry
+
+			// Items still listed keep their history, which would otherwise expire while their price does not change
+			if refresh {
+				entries, err = historyEntries(txn, item.ID)
+				if err != nil {
+					return err
+				}
+				summary.Refreshed = t
+			}
+
+			if changed {
+				if !found || item.Price < summary.Lowest {
+					summary.Lowest = item.Price
+				}
+				summary.Last = item.Price
+				summary.Points++
+
+				pointJson, err := json.Marshal(PricePoint{Time: t, Price: item.Price})
+				if err != nil {
+					return fmt.Errorf("marshalling price point into json: %w", err)
+				}
+				entries = append(entries, badger.NewEntry(historyKey(item.ID, t), pointJson).WithTTL(historyTTL))
+			}
+			summary.Seen = t
+
+			summaryJson, err := json.Marshal(summary)
+			if err != nil {
+				return fmt.Errorf("marshalling price summary into json: %w", err)
+			}
+			entries = append(entries, badger.NewEntry(pricesKey(item.ID), summaryJson).WithTTL(historyTTL))
+
+			// Item URLs only contain the slug, keep an index to find the item from them
+			if item.Slug != "" && (changed || refresh) {
+				entries = append(entries, badger.NewEntry([]byte(slugKeyPrefix+item.Slug), []byte(item.ID)).WithTTL(historyTTL))
+			}
+
+			for _, e := range entries {
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// historyEntries returns the recorded price observations of an item as entries with a fresh TTL
+func historyEntries(txn *badger.Txn, itemID string) ([]*badger.Entry, error) {
+	var entries []*badger.Entry
+
+	it := txn.NewIterator(badger.IteratorOptions{
+		PrefetchSize:   16,
+		PrefetchValues: true,
+		Prefix:         historyItemPrefix(itemID),
+	})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		value, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting price point from DB: %w", err)
+		}
+
+		entries = append(entries, badger.NewEntry(it.Item().KeyCopy(nil), value).WithTTL(historyTTL))
+	}
+
+	return entries, nil
+}
+
+// PriceHistory returns all the recorded observations of the price of an item, oldest first
+func (db *Database) PriceHistory(itemID string) ([]PricePoint, error) {
+	var points []PricePoint
+
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   64,
+			PrefetchValues: true,
+			Prefix:         historyItemPrefix(itemID),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			point := PricePoint{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &point)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling price point from DB: %w", err)
+			}
+
+			points = append(points, point)
+		}
+
+		return nil
+	})
+
+	return points, err
+}
+
+// summarize builds the price summary of an item from its history
+func summarize(txn *badger.Txn, itemID string) (priceSummary, bool, error) {
+	summary := priceSummary{}
+
+	it := txn.NewIterator(badger.IteratorOptions{
+		PrefetchSize:   16,
+		PrefetchValues: true,
+		Prefix:         historyItemPrefix(itemID),
+	})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		point := PricePoint{}
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &point)
+		})
+		if err != nil {
+			return summary, false, fmt.Errorf("unmarshalling price point from DB: %w", err)
+		}
+
+		if summary.Points == 0 || point.Price < summary.Lowest {
+			summary.Lowest = point.Price
+		}
+		summary.Last = point.Price
+		summary.Points++
+		summary.Seen = point.Time
+	}
+
+	return summary, summary.Points > 0, nil
+}
+
+// LowestPrice returns the lowest recorded price for an item, and whether its price has changed since it was first seen
+func (db *Database) LowestPrice(itemID string) (float64, bool, error) {
+	summary := priceSummary{}
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(pricesKey(itemID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &summary)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("getting price summary from DB: %w", err)
+	}
+
+	return summary.Lowest, summary.Points > 1, nil
+}
+
+// LastPrice returns the price an item had the last time it was found in results, and when that was
+func (db *Database) LastPrice(itemID string) (float64, time.Time, bool, error) {
+	summary := priceSummary{}
+	found := true
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(pricesKey(itemID))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			// Items seen by older versions only have their history
+			summary, found, err = summarize(txn, itemID)
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("getting price summary from DB: %w", err)
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &summary)
+		})
+	})
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+
+	return summary.Last, summary.Seen, found, nil
+}
+
+// ItemIDFromSlug returns the ID of a previously seen item given the slug of its URL
+func (db *Database) ItemIDFromSlug(slug string) (string, error) {
+	var id string
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(slugKeyPrefix + slug))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			id = string(val)
+			return nil
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return "", nil
+	}
+
+	return id, err
+}
+
+func pricesKey(itemID string) []byte {
+	return []byte(pricesKeyPrefix + itemID)
+}
+
+func historyItemPrefix(itemID string) []byte {
+	return []byte(historyKeyPrefix + itemID + "_")
+}
+
+func historyKey(itemID string, t time.Time) []byte {
+	// Zero-padded so keys sort chronologically
+	return append(historyItemPrefix(itemID), []byte(fmt.Sprintf("%020d", t.UnixNano()))...)
+}
@@ -0,0 +1,53 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+func TestRecordPrices(t *testing.T) {
+	db, err := database.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, price := range []float64{100, 100, 80, 80, 90} {
+		err = db.RecordPrices([]wallapop.Item{{ID: "a", Price: price}}, now.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	points, err := db.PriceHistory("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("Expected a point per price change, got %v", points)
+	}
+
+	lowest, changed, err := db.LowestPrice("a")
+	if err != nil || lowest != 80 || !changed {
+		t.Fatalf("Expected lowest price 80 after changes, got %v %v (%v)", lowest, changed, err)
+	}
+
+	// Seeing the item again at the same price does not add a point, but is shown as the last time it was seen
+	later := now.Add(6 * time.Hour)
+	err = db.RecordPrices([]wallapop.Item{{ID: "a", Price: 90}}, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	price, seen, found, err := db.LastPrice("a")
+	if err != nil || !found || price != 90 || !seen.Equal(later) {
+		t.Fatalf("Expected 90 last seen at %v, got %v at %v (%v %v)", later, price, seen, found, err)
+	}
+
+	if points, _ := db.PriceHistory("a"); len(points) != 3 {
+		t.Fatalf("Expected no point without a price change, got %v", points)
+	}
+}
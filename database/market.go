@@ -0,0 +1,164 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"roob.re/wallabot/wallapop"
+)
+
+const marketKeyPrefix = "market_"
+
+const (
+	// Prices not seen for this long are dropped from the distribution
+	marketMaxAge = 14 * 24 * time.Hour
+	// Maximum number of items kept for the distribution of each search
+	marketMaxSize = 1000
+	// Below this number of samples the distribution is not considered meaningful
+	marketMinSamples = 20
+)
+
+// Market is a rolling distribution of the prices seen by a search.
+// Searches only fetch results up to a bit above their MaxPrice, so this is the distribution of what the search can
+// almost afford rather than of the whole market: its median and percentiles are biased low, and so is how much of a
+// deal an item looks compared to them.
+type Market struct {
+	Samples map[string]MarketSample // By item ID, so items seen on every run are only counted once
+}
+
+type MarketSample struct {
+	Price float64
+	Seen  time.Time
+}
+
+// Observe adds the prices of items to the distribution, and drops old samples
+func (m *Market) Observe(items []wallapop.Item, now time.Time) {
+	if m.Samples == nil {
+		m.Samples = map[string]MarketSample{}
+	}
+
+	for _, item := range items {
+		// Free or placeholder prices say nothing about the market
+		if item.Price <= 1 {
+			continue
+		}
+
+		m.Samples[item.ID] = MarketSample{Price: item.Price, Seen: now}
+	}
+
+	for id, sample := range m.Samples {
+		if now.Sub(sample.Seen) > marketMaxAge {
+			delete(m.Samples, id)
+		}
+	}
+
+	if len(m.Samples) <= marketMaxSize {
+		return
+	}
+
+	// Too many samples, drop the least recently seen ones
+	ids := make([]string, 0, len(m.Samples))
+	for id := range m.Samples {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return m.Samples[ids[i]].Seen.Before(m.Samples[ids[j]].Seen)
+	})
+	for _, id := range ids[:len(ids)-marketMaxSize] {
+		delete(m.Samples, id)
+	}
+}
+
+// Meaningful returns whether there are enough samples for statistics to be trusted
+func (m *Market) Meaningful() bool {
+	return len(m.Samples) >= marketMinSamples
+}
+
+func (m *Market) sorted() []float64 {
+	prices := make([]float64, 0, len(m.Samples))
+	for _, sample := range m.Samples {
+		prices = append(prices, sample.Price)
+	}
+	sort.Float64s(prices)
+
+	return prices
+}
+
+// Percentile returns the price below which p percent of the samples fall
+func (m *Market) Percentile(p float64) float64 {
+	prices := m.sorted()
+	if len(prices) == 0 {
+		return 0
+	}
+
+	idx := int(math.Round(p / 100 * float64(len(prices)-1)))
+	return prices[idx]
+}
+
+func (m *Market) Median() float64 {
+	return m.Percentile(50)
+}
+
+// BelowMedian returns how much cheaper price is than the median, as a fraction of the latter.
+// It returns 0 if the price is not below the median or there are not enough samples.
+func (m *Market) BelowMedian(price float64) float64 {
+	if !m.Meaningful() {
+		return 0
+	}
+
+	median := m.Median()
+	if median == 0 || price >= median {
+		return 0
+	}
+
+	return (median - price) / median
+}
+
+// MarketUpdate adds the prices of items seen by a search to its distribution, and returns the updated distribution
+func (db *Database) MarketUpdate(userID int, search string, items []wallapop.Item, now time.Time) (*Market, error) {
+	key := marketKey(userID, search)
+	market := &Market{}
+
+	err := db.bdg.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		switch {
+		case errors.Is(err, badger.ErrKeyNotFound):
+		case err != nil:
+			return err
+		default:
+			err = item.Value(func(val []byte) error {
+				return json.Unmarshal(val, market)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling market from DB: %w", err)
+			}
+		}
+
+		market.Observe(items, now)
+
+		marketJson, err := json.Marshal(market)
+		if err != nil {
+			return fmt.Errorf("marshalling market into json: %w", err)
+		}
+
+		return txn.Set(key, marketJson)
+	})
+
+	return market, err
+}
+
+// MarketDelete removes the price distribution of a search
+func (db *Database) MarketDelete(userID int, search string) error {
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		return txn.Delete(marketKey(userID, search))
+	})
+}
+
+func marketKey(userID int, search string) []byte {
+	return []byte(fmt.Sprintf("%s%d_%s", marketKeyPrefix, userID, search))
+}
@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+func TestMarket(t *testing.T) {
+	now := time.Now()
+
+	var items []wallapop.Item
+	for i := 1; i <= 100; i++ {
+		items = append(items, wallapop.Item{ID: fmt.Sprint(i), Price: float64(i * 10)})
+	}
+	// Placeholder prices should be ignored
+	items = append(items, wallapop.Item{ID: "free", Price: 0}, wallapop.Item{ID: "one", Price: 1})
+
+	m := &database.Market{}
+	m.Observe(items[:10], now.Add(-30*24*time.Hour))
+	if len(m.Samples) != 10 || m.Meaningful() {
+		t.Fatalf("Expected 10 samples, got %d", len(m.Samples))
+	}
+
+	m.Observe(items[10:], now)
+	if len(m.Samples) != 90 {
+		t.Fatalf("Expected old samples to be dropped, got %d samples", len(m.Samples))
+	}
+
+	if median := m.Median(); median != 560 {
+		t.Fatalf("Expected median 560, got %.0f", median)
+	}
+
+	if p25 := m.Percentile(25); p25 != 330 {
+		t.Fatalf("Expected 25th percentile 330, got %.0f", p25)
+	}
+
+	if below := m.BelowMedian(420); below != 0.25 {
+		t.Fatalf("Expected 420 to be 25%% below the median, got %.2f", below)
+	}
+
+	if below := m.BelowMedian(600); below != 0 {
+		t.Fatalf("Expected 600 not to be below the median, got %.2f", below)
+	}
+}
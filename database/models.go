@@ -2,7 +2,9 @@ package database
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"roob.re/wallabot/telegram/search"
 	"roob.re/wallabot/wallapop"
@@ -14,13 +16,20 @@ const (
 )
 
 type User struct {
-	ID       int
-	Name     string
-	ChatID   int64
-	Lat      float64
-	Long     float64
-	RadiusKm int
-	Searches SavedSearches
+	ID         int
+	Name       string
+	ChatID     int64
+	Lat        float64
+	Long       float64
+	RadiusKm   int
+	Quiet      QuietHours
+	NotifyGone bool      // Tell the user when a notified item is sold, reserved or deleted
+	Excluded   []string  // Words or phrases that discard items for all searches of the user
+	Blocked    []string  // IDs or profile slugs of sellers whose items are discarded for all searches of the user
+	Notified   SentItems `json:"-"` // Items notified by any search and their price, so overlapping searches do not notify twice
+	Searches   SavedSearches
+
+	sent map[string]SentItems // Sent items of each search as loaded to be changed, to write only the ones that change
 }
 
 func (u *User) Location() (float64, float64) {
@@ -31,16 +40,73 @@ func (u *User) Location() (float64, float64) {
 	return defaultLat, defaultLong
 }
 
+// BlocksSeller returns whether the user has blocked the seller of an item
+func (u *User) BlocksSeller(item *wallapop.Item) bool {
+	for _, seller := range u.Blocked {
+		if seller == item.Seller.ID || (item.Seller.Slug != "" && seller == item.Seller.Slug) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AlreadyNotified returns whether the user was notified about an item, by any search, at the same or a lower price
+func (u *User) AlreadyNotified(itemID string, price float64) bool {
+	notifiedPrice, notified := u.Notified[itemID]
+	return notified && notifiedPrice <= price
+}
+
+// Forget removes any record of an item from the user and all their searches, and returns whether there was any
+func (u *User) Forget(itemID string) bool {
+	_, known := u.Notified[itemID]
+	delete(u.Notified, itemID)
+
+	for _, search := range u.Searches {
+		if _, sent := search.SentItems[itemID]; sent {
+			known = true
+		}
+		search.Forget(itemID)
+	}
+
+	return known
+}
+
+// MatchingSearches returns the given search followed by any other unmuted search of the user that also matches item
+func (u *User) MatchingSearches(item *wallapop.Item, first string) []string {
+	var others []string
+	for keywords, search := range u.Searches {
+		if keywords == first || search.Muted {
+			continue
+		}
+
+		search.LegacyFill()
+		if search.Search.Matches(item) {
+			others = append(others, keywords)
+		}
+	}
+	sort.Strings(others)
+
+	return append([]string{first}, others...)
+}
+
 type SavedSearches map[string]*SavedSearch
 
 type SavedSearch struct {
-	Search    search.Search
-	Muted     bool
-	SentItems SentItems
-	Keywords  string  // Deprecated
-	RadiusKm  int     // Deprecated
-	MinPrice  float64 // Deprecated
-	MaxPrice  float64 // Deprecated
+	Search     search.Search
+	Muted      bool
+	Delivery   DeliveryMode
+	LastDigest time.Time     // Last time a digest was sent for this search
+	NextRun    time.Time     // When the search is due to run again
+	LastRun    time.Time     // When the search last ran
+	LastSeen   []string      // IDs of the items found by the last run
+	Rate       float64       // Average number of new items found per hour, used to adapt how often the search runs
+	Cadence    time.Duration // How often the search runs, as adapted to Rate, unless it sets its own interval
+	SentItems  SentItems     `json:"-"` // Stored in their own keys, see loadSent
+	Keywords   string        // Deprecated
+	RadiusKm   int           // Deprecated
+	MinPrice   float64       // Deprecated
+	MaxPrice   float64       // Deprecated
 }
 
 func (ss *SavedSearch) LegacyFill() {
@@ -63,7 +129,7 @@ func (ss *SavedSearch) LegacyFill() {
 func (ss SavedSearch) Emojify() string {
 	str := &strings.Builder{}
 
-	fmt.Fprintf(str, "- `%s` | %d 🔔", ss.Search.Keywords, len(ss.SentItems))
+	fmt.Fprintf(str, "- %s | %d 🔔", markdownCode(ss.Search.Keywords), len(ss.SentItems))
 	fmt.Fprintf(str, "| <= %d€", ss.Search.MaxPrice)
 	if ss.Search.RadiusKm != 0 {
 		fmt.Fprintf(str, " | 📍 %dKm", ss.Search.RadiusKm)
@@ -73,13 +139,119 @@ func (ss SavedSearch) Emojify() string {
 		fmt.Fprintf(str, " | 🔬 Strict")
 	}
 
+	if ss.Search.Fuzzy != 0 {
+		fmt.Fprintf(str, " | 🔤 Fuzzy %d", ss.Search.Fuzzy)
+	}
+
+	if ss.Search.Desc {
+		fmt.Fprintf(str, " | 📝 Description")
+	}
+
+	if ss.Search.Match != "" {
+		fmt.Fprintf(str, " | ✅ %s", markdownCode(ss.Search.Match))
+	}
+
+	if ss.Search.Exclude != "" {
+		fmt.Fprintf(str, " | 🚫 %s", markdownCode(ss.Search.Exclude))
+	}
+
+	if ss.Search.Sellers != "" {
+		fmt.Fprintf(str, " | 👤 Only %d sellers", len(strings.Split(ss.Search.Sellers, ",")))
+	}
+
+	if ss.Search.Percentile != 0 {
+		fmt.Fprintf(str, " | 💰 P%d", ss.Search.Percentile)
+	}
+
+	if ss.Search.Scam != search.ScamOff {
+		fmt.Fprintf(str, " | ⚠️ %s scams", ss.Search.Scam)
+	}
+
+	if ss.Search.CheckReposts() {
+		fmt.Fprintf(str, " | 🔁 %s reposts", ss.Search.Reposts)
+	}
+
+	if ss.Search.Fresh {
+		fmt.Fprintf(str, " | 🧊 No cache")
+	}
+
 	if ss.Search.NoZero {
 		fmt.Fprintf(str, " | ⛔ No zero")
 	}
 
+	if ss.Search.MinDrop.Amount != 0 {
+		fmt.Fprintf(str, " | 📉 >= %s", ss.Search.MinDrop)
+	}
+
+	if ss.Search.Every != 0 {
+		fmt.Fprintf(str, " | ⏱ every %s", ss.Search.Every)
+	} else if ss.Cadence != 0 {
+		fmt.Fprintf(str, " | ⏱ ~%s", search.Interval(ss.Cadence))
+	}
+
+	if ss.Delivery.Interval() != 0 {
+		fmt.Fprintf(str, " | 📰 %s", ss.Delivery)
+	}
+
 	return str.String()
 }
 
+// markdownCode formats text as inline code for legacy Markdown, which cannot escape backticks inside code, so they
+// are left out of it and escaped instead
+func markdownCode(text string) string {
+	var parts []string
+	for _, part := range strings.Split(text, "`") {
+		if part != "" {
+			part = "`" + part + "`"
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, "\\`")
+}
+
+// DeliveryMode controls whether matches for a search are sent as they are found or grouped in periodic digests
+type DeliveryMode string
+
+const (
+	DeliveryInstant DeliveryMode = "instant"
+	DeliveryHourly  DeliveryMode = "hourly"
+	DeliveryDaily   DeliveryMode = "daily"
+)
+
+func ParseDeliveryMode(mode string) (DeliveryMode, error) {
+	switch d := DeliveryMode(strings.ToLower(mode)); d {
+	case DeliveryInstant, DeliveryHourly, DeliveryDaily:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown delivery mode %q, must be one of %s, %s or %s",
+			mode, DeliveryInstant, DeliveryHourly, DeliveryDaily)
+	}
+}
+
+// Interval returns how often digests should be sent, or 0 if items are delivered instantly
+func (d DeliveryMode) Interval() time.Duration {
+	switch d {
+	case DeliveryHourly:
+		return time.Hour
+	case DeliveryDaily:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// DigestDue returns whether enough time has passed since the last digest for a new one to be sent
+func (ss *SavedSearch) DigestDue(now time.Time) bool {
+	interval := ss.Delivery.Interval()
+	return interval != 0 && now.Sub(ss.LastDigest) >= interval
+}
+
+// Forget removes any record of an item from the search, so it is treated as new if it shows up again
+func (ss *SavedSearch) Forget(itemID string) {
+	delete(ss.SentItems, itemID)
+}
+
 // SentItems is a map of sent itemIDs and their price when they were sent the last time
 type SentItems map[string]float64
 
@@ -107,7 +279,20 @@ func (ss SavedSearches) Delete(keywords string) bool {
 
 // Notification models a matching result for a search, which the user should be notified about
 type Notification struct {
-	User   *User
-	Item   *wallapop.Item
-	Search string
+	User       *User
+	Item       *wallapop.Item
+	Search     string
+	Gone       ItemStatus // Set if this notifies that a previously notified item is no longer available
+	BelowUsual float64    // How much cheaper than the median price seen by the search, as a fraction of the latter
+	Warnings   []string   // Reasons why the item looks like a scam, if the search wants them marked
+	FirstSeen  time.Time  // Set if the item looks like a repost, to when its first listing was seen
 }
+
+// ItemStatus describes why a previously notified item is no longer available
+type ItemStatus string
+
+const (
+	ItemSold     ItemStatus = "sold"
+	ItemReserved ItemStatus = "reserved"
+	ItemDeleted  ItemStatus = "deleted"
+)
@@ -0,0 +1,25 @@
+package database_test
+
+import (
+	"strings"
+	"testing"
+
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/telegram/search"
+)
+
+func TestSavedSearch_Emojify(t *testing.T) {
+	for _, tc := range []struct {
+		match    string
+		expected string
+	}{
+		{match: "rtx.*", expected: "✅ `rtx.*`"},
+		{match: "a`b", expected: "✅ `a`\\``b`"},
+		{match: "`ti`", expected: "✅ \\``ti`\\`"},
+	} {
+		ss := database.SavedSearch{Search: search.Search{Keywords: "gpu", Match: tc.match}}
+		if actual := ss.Emojify(); !strings.Contains(actual, tc.expected) {
+			t.Fatalf("Match %q: expected %q in %q", tc.match, tc.expected, actual)
+		}
+	}
+}
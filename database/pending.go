@@ -0,0 +1,105 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"roob.re/wallabot/wallapop"
+)
+
+const pendingKeyPrefix = "pending_"
+
+const (
+	// QueueQuiet holds notifications found while the user was in quiet hours
+	QueueQuiet = "quiet"
+	// QueueDigest holds notifications for searches delivered as periodic digests
+	QueueDigest = "digest"
+)
+
+// Pending is a notification that has been held back to be delivered later
+type Pending struct {
+	UserID        int
+	Search        string
+	Item          wallapop.Item
+	PreviousPrice float64 // Non-zero if this is a price drop of a previously seen item
+	BelowUsual    float64
+	Warnings      []string
+	FirstSeen     time.Time  // Set if the item looks like a repost
+	Gone          ItemStatus // Set if this tells that a notified item is no longer available, for no search in particular
+	Queued        time.Time
+}
+
+// Enqueue stores a pending notification in the given queue.
+// Enqueuing the same item for the same search again replaces the previous entry.
+func (db *Database) Enqueue(queue string, p Pending) error {
+	if p.UserID == 0 {
+		return fmt.Errorf("refusing to queue notification for user with id 0")
+	}
+
+	if p.Queued.IsZero() {
+		p.Queued = time.Now()
+	}
+
+	pJson, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshalling pending notification into json: %w", err)
+	}
+
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		return txn.Set(pendingKey(queue, p.UserID, p.Search, p.Item.ID), pJson)
+	})
+}
+
+// PendingList returns all pending notifications for a user in the given queue
+func (db *Database) PendingList(queue string, userID int) ([]Pending, error) {
+	var pending []Pending
+
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   64,
+			PrefetchValues: true,
+			Prefix:         pendingUserPrefix(queue, userID),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			p := Pending{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &p)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling pending notification from DB: %w", err)
+			}
+
+			pending = append(pending, p)
+		}
+
+		return nil
+	})
+
+	return pending, err
+}
+
+// PendingDelete removes the given notifications from the queue
+func (db *Database) PendingDelete(queue string, pending []Pending) error {
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		for _, p := range pending {
+			err := txn.Delete(pendingKey(queue, p.UserID, p.Search, p.Item.ID))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func pendingUserPrefix(queue string, userID int) []byte {
+	return []byte(fmt.Sprintf("%s%s_%d_", pendingKeyPrefix, queue, userID))
+}
+
+func pendingKey(queue string, userID int, search, itemID string) []byte {
+	return append(pendingUserPrefix(queue, userID), []byte(itemID+"_"+search)...)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily window, in the user's timezone, during which notifications are held back
+type QuietHours struct {
+	Start    int // Minutes after midnight
+	End      int // Minutes after midnight
+	Timezone string
+}
+
+// ParseQuietHours parses a window in the form "23:00-08:00" and an optional IANA timezone name
+func ParseQuietHours(window, timezone string) (QuietHours, error) {
+	q := QuietHours{Timezone: timezone}
+
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return q, fmt.Errorf("window %q must be in the form HH:MM-HH:MM", window)
+	}
+
+	var err error
+	q.Start, err = parseClock(parts[0])
+	if err != nil {
+		return q, fmt.Errorf("parsing start of window: %w", err)
+	}
+
+	q.End, err = parseClock(parts[1])
+	if err != nil {
+		return q, fmt.Errorf("parsing end of window: %w", err)
+	}
+
+	if q.Start == q.End {
+		return q, fmt.Errorf("window must not start and end at the same time")
+	}
+
+	if _, err = time.LoadLocation(timezone); err != nil {
+		return q, fmt.Errorf("unknown timezone %q", timezone)
+	}
+
+	return q, nil
+}
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid HH:MM time", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Enabled returns whether the user has configured a quiet window
+func (q QuietHours) Enabled() bool {
+	return q.Start != q.End
+}
+
+// Location returns the timezone of the user, defaulting to UTC if it is not set or not valid
+func (q QuietHours) Location() *time.Location {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// Active returns whether t falls inside the quiet window
+func (q QuietHours) Active(t time.Time) bool {
+	if !q.Enabled() {
+		return false
+	}
+
+	t = t.In(q.Location())
+	minute := t.Hour()*60 + t.Minute()
+
+	// Window does not cross midnight, e.g. 13:00-15:00
+	if q.Start < q.End {
+		return minute >= q.Start && minute < q.End
+	}
+
+	// Window crosses midnight, e.g. 23:00-08:00
+	return minute >= q.Start || minute < q.End
+}
+
+func (q QuietHours) String() string {
+	if !q.Enabled() {
+		return "disabled"
+	}
+
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", q.Start/60, q.Start%60, q.End/60, q.End%60, q.Location())
+}
@@ -0,0 +1,86 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"roob.re/wallabot/database"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	for _, tc := range []struct {
+		window   string
+		timezone string
+		expected database.QuietHours
+		err      bool
+	}{
+		{
+			window:   "23:00-08:00",
+			timezone: "Europe/Madrid",
+			expected: database.QuietHours{Start: 23 * 60, End: 8 * 60, Timezone: "Europe/Madrid"},
+		},
+		{
+			window:   " 13:30 - 15:45 ",
+			expected: database.QuietHours{Start: 13*60 + 30, End: 15*60 + 45},
+		},
+		{window: "23:00", err: true},
+		{window: "23:00-08:00-09:00", err: true},
+		{window: "25:00-08:00", err: true},
+		{window: "23:00-8h", err: true},
+		{window: "08:00-08:00", err: true},
+		{window: "23:00-08:00", timezone: "Mars/Olympus", err: true},
+	} {
+		actual, err := database.ParseQuietHours(tc.window, tc.timezone)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%q %q: expected an error, got %+v", tc.window, tc.timezone, actual)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q %q: %v", tc.window, tc.timezone, err)
+		}
+
+		if actual != tc.expected {
+			t.Fatalf("%q %q: expected %+v, got %+v", tc.window, tc.timezone, tc.expected, actual)
+		}
+	}
+}
+
+func TestQuietHours_Active(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+
+	overnight := database.QuietHours{Start: 23 * 60, End: 8 * 60}
+	afternoon := database.QuietHours{Start: 13 * 60, End: 15 * 60}
+	overnightMadrid := database.QuietHours{Start: 23 * 60, End: 8 * 60, Timezone: "Europe/Madrid"}
+
+	for _, tc := range []struct {
+		name     string
+		quiet    database.QuietHours
+		at       time.Time
+		expected bool
+	}{
+		{name: "disabled", quiet: database.QuietHours{}, at: time.Date(2021, 7, 1, 3, 0, 0, 0, time.UTC), expected: false},
+		{name: "before midnight", quiet: overnight, at: time.Date(2021, 7, 1, 23, 30, 0, 0, time.UTC), expected: true},
+		{name: "after midnight", quiet: overnight, at: time.Date(2021, 7, 1, 3, 0, 0, 0, time.UTC), expected: true},
+		{name: "start is inclusive", quiet: overnight, at: time.Date(2021, 7, 1, 23, 0, 0, 0, time.UTC), expected: true},
+		{name: "end is exclusive", quiet: overnight, at: time.Date(2021, 7, 1, 8, 0, 0, 0, time.UTC), expected: false},
+		{name: "daytime", quiet: overnight, at: time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC), expected: false},
+		{name: "inside same day window", quiet: afternoon, at: time.Date(2021, 7, 1, 14, 0, 0, 0, time.UTC), expected: true},
+		{name: "outside same day window", quiet: afternoon, at: time.Date(2021, 7, 1, 23, 0, 0, 0, time.UTC), expected: false},
+		// 22:30 UTC is 00:30 in Madrid during summer time
+		{name: "timezone inside", quiet: overnightMadrid, at: time.Date(2021, 7, 1, 22, 30, 0, 0, time.UTC), expected: true},
+		// 07:00 UTC is 09:00 in Madrid during summer time
+		{name: "timezone outside", quiet: overnightMadrid, at: time.Date(2021, 7, 1, 7, 0, 0, 0, time.UTC), expected: false},
+		{name: "time in other zone", quiet: overnightMadrid, at: time.Date(2021, 7, 1, 23, 30, 0, 0, madrid), expected: true},
+		{name: "unknown timezone is utc", quiet: database.QuietHours{Start: 23 * 60, End: 8 * 60, Timezone: "Nowhere"}, at: time.Date(2021, 7, 1, 23, 30, 0, 0, time.UTC), expected: true},
+	} {
+		if actual := tc.quiet.Active(tc.at); actual != tc.expected {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
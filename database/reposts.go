@@ -0,0 +1,71 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const fingerprintKeyPrefix = "fingerprint_"
+
+// Fingerprints are dropped after this long, so only items re-listed within it are recognised as reposts
+const fingerprintTTL = 90 * 24 * time.Hour
+
+// Fingerprint identifies the listing of an item regardless of its ID, so it can be recognised if it is re-listed
+type Fingerprint struct {
+	ItemID    string
+	Hash      uint64 // Perceptual hash of the main picture
+	Title     string
+	Price     float64
+	FirstSeen time.Time // When the item was first seen, or the item it is a repost of
+	RepostOf  string    // ID of the earliest listing of the same item, if this is a repost
+}
+
+// Fingerprints returns the fingerprints of all items seen from a seller
+func (db *Database) Fingerprints(sellerID string) ([]Fingerprint, error) {
+	var fingerprints []Fingerprint
+
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   16,
+			PrefetchValues: true,
+			Prefix:         fingerprintSellerPrefix(sellerID),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			f := Fingerprint{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &f)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling fingerprint from DB: %w", err)
+			}
+
+			fingerprints = append(fingerprints, f)
+		}
+
+		return nil
+	})
+
+	return fingerprints, err
+}
+
+// FingerprintSave stores the fingerprint of an item from a seller
+func (db *Database) FingerprintSave(sellerID string, f Fingerprint) error {
+	fJson, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshalling fingerprint into json: %w", err)
+	}
+
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		key := append(fingerprintSellerPrefix(sellerID), []byte(f.ItemID)...)
+		return txn.SetEntry(badger.NewEntry(key, fJson).WithTTL(fingerprintTTL))
+	})
+}
+
+func fingerprintSellerPrefix(sellerID string) []byte {
+	return []byte(fingerprintKeyPrefix + sellerID + "_")
+}
@@ -0,0 +1,532 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"roob.re/wallabot/wallapop"
+)
+
+// Items users have been notified about are stored in their own keys, sent/<user>/<search>/<item>, rather than in the
+// user record, so notifying about an item does not rewrite every other one. Items notified by any search of the user,
+// kept in User.Notified, use an empty search.
+const sentKeyPrefix = "sent/"
+
+// Records of items found in results are refreshed at most this often, so searches do not rewrite them on every run
+const sentSeenResolution = 24 * time.Hour
+
+// Expired records are deleted in batches, so a single transaction does not grow too big
+const sentExpireBatch = 1000
+
+// sentRecord is the value stored for each notified item
+type sentRecord struct {
+	Price float64
+	Title string // Kept to tell the user about the item once it is deleted and cannot be looked up anymore
+	Slug  string
+	Sent  time.Time // When the item was last notified at this price
+	Seen  time.Time // When the item was last found in results or confirmed available, records not seen in a while expire
+}
+
+// loadSent fills the sent items of a user and their searches from their own keys
+func (db *Database) loadSent(txn *badger.Txn, user *User) error {
+	user.Notified = SentItems{}
+	for _, ss := range user.Searches {
+		ss.SentItems = SentItems{}
+	}
+
+	prefix := sentUserPrefix(user.ID)
+	it := txn.NewIterator(badger.IteratorOptions{
+		PrefetchSize:   64,
+		PrefetchValues: true,
+		Prefix:         prefix,
+	})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		search, itemID, ok := parseSentKey(prefix, it.Item().Key())
+		if !ok {
+			continue
+		}
+
+		record := sentRecord{}
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &record)
+		})
+		if err != nil {
+			return fmt.Errorf("unmarshalling sent item from DB: %w", err)
+		}
+
+		if search == "" {
+			user.Notified[itemID] = record.Price
+		} else if ss := user.Searches.Get(search); ss != nil {
+			ss.SentItems[itemID] = record.Price
+		}
+	}
+
+	return nil
+}
+
+// saveSent writes the sent items of a user and their searches that changed since they were loaded, and deletes the
+// ones that were forgotten, including those of deleted searches
+func (db *Database) saveSent(txn *badger.Txn, user *User) error {
+	current := map[string]SentItems{"": user.Notified}
+	for keywords, ss := range user.Searches {
+		current[keywords] = ss.SentItems
+	}
+
+	now := time.Now()
+	for search, items := range current {
+		for itemID, price := range items {
+			if loaded, found := user.sent[search][itemID]; found && loaded == price {
+				continue
+			}
+
+			if err := setSent(txn, user.ID, search, itemID, sentRecord{Price: price, Sent: now, Seen: now}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for search, items := range user.sent {
+		for itemID := range items {
+			if _, found := current[search][itemID]; found {
+				continue
+			}
+
+			if err := txn.Delete(sentKey(user.ID, search, itemID)); err != nil {
+				return fmt.Errorf("deleting sent item: %w", err)
+			}
+		}
+	}
+
+	trackSent(user)
+	return nil
+}
+
+// trackSent remembers the sent items of a user and their searches as they are, so saveSent writes only what changes
+func trackSent(user *User) {
+	user.sent = map[string]SentItems{"": {}}
+	for itemID, price := range user.Notified {
+		user.sent[""][itemID] = price
+	}
+
+	for keywords, ss := range user.Searches {
+		user.sent[keywords] = SentItems{}
+		for itemID, price := range ss.SentItems {
+			user.sent[keywords][itemID] = price
+		}
+	}
+}
+
+// MarkSent records that a user has been notified about an item, and that the given searches matched it, which stop
+// watching it. Only the records of that item are written.
+func (db *Database) MarkSent(userID int, item *wallapop.Item, searches ...string) error {
+	// The user is read apart, so that updating them meanwhile does not make this conflict
+	var user *User
+	err := db.User(userID, func(u *User) error {
+		user = u
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		now := time.Now()
+		record := sentRecord{Price: item.Price, Title: item.Title, Slug: item.Slug, Sent: now, Seen: now}
+		if err := setSent(txn, userID, "", item.ID, record); err != nil {
+			return err
+		}
+
+		for _, keywords := range searches {
+			if user.Searches.Get(keywords) == nil {
+				continue
+			}
+
+			if err := setSent(txn, userID, keywords, item.ID, record); err != nil {
+				return err
+			}
+		}
+
+		return unwatch(txn, userID, item.ID, searches...)
+	})
+}
+
+// SentSeen records that items were found in the results of a search, so the user does not forget about them. Both the
+// records of the search and the ones of the user are refreshed, items that were not notified are ignored.
+func (db *Database) SentSeen(userID int, search string, itemIDs []string, now time.Time) error {
+	return db.bdg.Update(func(txn *badger.Txn) error {
+		for _, itemID := range itemIDs {
+			for _, key := range [][]byte{sentKey(userID, search, itemID), sentKey(userID, "", itemID)} {
+				item, err := txn.Get(key)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("getting sent item from DB: %w", err)
+				}
+
+				record := sentRecord{}
+				err = item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &record)
+				})
+				if err != nil {
+					return fmt.Errorf("unmarshalling sent item from DB: %w", err)
+				}
+
+				if now.Sub(record.Seen) < sentSeenResolution {
+					continue
+				}
+
+				record.Seen = now
+				recordJson, err := json.Marshal(record)
+				if err != nil {
+					return fmt.Errorf("marshalling sent item into json: %w", err)
+				}
+
+				if err := txn.Set(key, recordJson); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// SentItem returns what is known about an item the user has been notified about, which may be only its ID
+func (db *Database) SentItem(userID int, itemID string) (*wallapop.Item, error) {
+	record := sentRecord{}
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(sentKey(userID, "", itemID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &record)
+		})
+	})
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, fmt.Errorf("getting sent item from DB: %w", err)
+	}
+
+	return &wallapop.Item{ID: itemID, Title: record.Title, Slug: record.Slug, Price: record.Price}, nil
+}
+
+// SentCount returns how many items have been notified by the searches of every user. Only keys are read.
+func (db *Database) SentCount() (int, error) {
+	count := 0
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			Prefix: []byte(sentKeyPrefix),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if _, search, _, ok := parseSentUserKey(it.Item().Key()); ok && search != "" {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// SentRef identifies an item notified by a search of a user
+type SentRef struct {
+	UserID int
+	Search string
+	ItemID string
+}
+
+// SentStale returns up to limit items notified by searches that have not been found in results nor checked since
+// before the given time, least recently seen first
+func (db *Database) SentStale(before time.Time, limit int) ([]SentRef, error) {
+	type stale struct {
+		ref  SentRef
+		seen time.Time
+	}
+	var found []stale
+
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   64,
+			PrefetchValues: true,
+			Prefix:         []byte(sentKeyPrefix),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			userID, search, itemID, ok := parseSentUserKey(it.Item().Key())
+			// Records of the user as a whole are refreshed along with the ones of their searches
+			if !ok || search == "" {
+				continue
+			}
+
+			record := sentRecord{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &record)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling sent item from DB: %w", err)
+			}
+
+			if seen := record.lastSeen(); seen.Before(before) {
+				found = append(found, stale{ref: SentRef{UserID: userID, Search: search, ItemID: itemID}, seen: seen})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seen.Before(found[j].seen)
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	refs := make([]SentRef, 0, len(found))
+	for _, s := range found {
+		refs = append(refs, s.ref)
+	}
+
+	return refs, nil
+}
+
+// lastSeen returns when the item was last known to be listed
+func (r sentRecord) lastSeen() time.Time {
+	if r.Seen.IsZero() {
+		return r.Sent
+	}
+
+	return r.Seen
+}
+
+// SentExpire deletes the records of notified items that have not been found in any results since before the given
+// time, and returns how many were deleted. If those items show up again, they are notified as new.
+func (db *Database) SentExpire(before time.Time) (int, error) {
+	var expired [][]byte
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   64,
+			PrefetchValues: true,
+			Prefix:         []byte(sentKeyPrefix),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			record := sentRecord{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &record)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling sent item from DB: %w", err)
+			}
+
+			if record.lastSeen().Before(before) {
+				expired = append(expired, it.Item().KeyCopy(nil))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(expired); start += sentExpireBatch {
+		end := start + sentExpireBatch
+		if end > len(expired) {
+			end = len(expired)
+		}
+
+		err := db.bdg.Update(func(txn *badger.Txn) error {
+			for _, key := range expired[start:end] {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return start, fmt.Errorf("deleting expired sent items: %w", err)
+		}
+	}
+
+	return len(expired), nil
+}
+
+// MigrateSentItems moves sent and watched items stored in user records by older versions into their own keys, and
+// returns how many users were migrated
+func (db *Database) MigrateSentItems() (int, error) {
+	var pending []int
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchSize:   64,
+			PrefetchValues: true,
+			Prefix:         []byte(userKeyPrefix),
+		})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			legacy := legacySent{}
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &legacy)
+			})
+			if err != nil {
+				return fmt.Errorf("unmarshalling user from DB: %w", err)
+			}
+
+			if !legacy.empty() {
+				pending = append(pending, legacy.ID)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range pending {
+		err := db.bdg.Update(func(txn *badger.Txn) error {
+			legacy := legacySent{}
+			user, err := db.getUser(userKey(id), func(key []byte) (*badger.Item, error) {
+				item, err := txn.Get(key)
+				if err != nil {
+					return nil, err
+				}
+
+				return item, item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &legacy)
+				})
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := db.loadSent(txn, user); err != nil {
+				return err
+			}
+			trackSent(user)
+
+			// Items already in their own keys are newer than the legacy ones
+			merge(user.Notified, legacy.Notified)
+			for keywords, ls := range legacy.Searches {
+				if ss := user.Searches.Get(keywords); ss != nil {
+					merge(ss.SentItems, ls.SentItems)
+				}
+
+				for itemID, price := range ls.Watched {
+					recordJson, err := json.Marshal(watchedRecord{Price: price, Seen: time.Now()})
+					if err != nil {
+						return fmt.Errorf("marshalling watched item into json: %w", err)
+					}
+
+					entry := badger.NewEntry(watchedKey(id, keywords, itemID), recordJson).WithTTL(watchedTTL)
+					if err := txn.SetEntry(entry); err != nil {
+						return err
+					}
+				}
+			}
+
+			return db.putUser(user, txn)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("migrating sent items of user %d: %w", id, err)
+		}
+	}
+
+	return len(pending), nil
+}
+
+// legacySent holds the sent and watched items stored in user records by older versions
+type legacySent struct {
+	ID       int
+	Notified SentItems
+	Searches map[string]struct {
+		SentItems SentItems
+		Watched   SentItems
+	}
+}
+
+func (l legacySent) empty() bool {
+	if len(l.Notified) > 0 {
+		return false
+	}
+
+	for _, ss := range l.Searches {
+		if len(ss.SentItems) > 0 || len(ss.Watched) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func merge(dst, src SentItems) {
+	for itemID, price := range src {
+		if _, found := dst[itemID]; !found {
+			dst[itemID] = price
+		}
+	}
+}
+
+func setSent(txn *badger.Txn, userID int, search, itemID string, record sentRecord) error {
+	recordJson, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshalling sent item into json: %w", err)
+	}
+
+	return txn.Set(sentKey(userID, search, itemID), recordJson)
+}
+
+func sentUserPrefix(userID int) []byte {
+	return []byte(sentKeyPrefix + fmt.Sprint(userID) + "/")
+}
+
+func sentKey(userID int, search, itemID string) []byte {
+	return append(sentUserPrefix(userID), []byte(search+"/"+itemID)...)
+}
+
+// parseSentUserKey returns the user, search and item of a sent key
+func parseSentUserKey(key []byte) (int, string, string, bool) {
+	rest := strings.TrimPrefix(string(key), sentKeyPrefix)
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return 0, "", "", false
+	}
+
+	userID, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return 0, "", "", false
+	}
+
+	search, itemID, ok := parseSentKey(nil, []byte(rest[i+1:]))
+	return userID, search, itemID, ok
+}
+
+// parseSentKey returns the search and item of a sent key. Searches may contain slashes, item IDs do not.
+func parseSentKey(prefix, key []byte) (string, string, bool) {
+	rest := string(key[len(prefix):])
+	i := strings.LastIndex(rest, "/")
+	if i < 0 {
+		return "", "", false
+	}
+
+	return rest[:i], rest[i+1:], true
+}
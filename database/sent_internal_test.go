@@ -0,0 +1,83 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"roob.re/wallabot/telegram/search"
+)
+
+// Tests in this file write keys in formats that cannot be produced through the exported API
+
+func TestMigrateSentItems(t *testing.T) {
+	db, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A user as stored by older versions, with sent items in its record
+	legacy, _ := json.Marshal(map[string]interface{}{
+		"ID":       1,
+		"Notified": SentItems{"a": 10},
+		"Searches": map[string]interface{}{
+			"rtx 3080": map[string]interface{}{
+				"Search":    search.Search{Keywords: "rtx 3080"},
+				"SentItems": SentItems{"a": 10},
+				"Watched":   SentItems{"c": 500},
+			},
+		},
+	})
+	err = db.bdg.Update(func(txn *badger.Txn) error {
+		return txn.Set(userKey(1), legacy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := db.MigrateSentItems()
+	if err != nil || migrated != 1 {
+		t.Fatalf("Expected 1 migrated user, got %d (%v)", migrated, err)
+	}
+	if migrated, _ = db.MigrateSentItems(); migrated != 0 {
+		t.Fatalf("Expected migration to run once, migrated %d users again", migrated)
+	}
+
+	if price, watched, err := db.Watched(1, "rtx 3080", "c"); err != nil || !watched || price != 500 {
+		t.Fatalf("Expected c to be watched at 500 after migration, got %v %v (%v)", price, watched, err)
+	}
+
+	err = db.UserWithSent(1, func(u *User) error {
+		if u.Notified["a"] != 10 || u.Searches.Get("rtx 3080").SentItems["a"] != 10 {
+			t.Fatalf("Expected a to be sent after migration, got %v", u.Notified)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSentExpire_NeverSeen(t *testing.T) {
+	db, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records written before items were tracked as seen count from when they were sent
+	now := time.Now()
+	err = db.bdg.Update(func(txn *badger.Txn) error {
+		return setSent(txn, 1, "", "unseen", sentRecord{Price: 10, Sent: now})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expired, err := db.SentExpire(now.Add(-time.Hour)); err != nil || expired != 0 {
+		t.Fatalf("Expected a recently sent record to be kept, expired %d (%v)", expired, err)
+	}
+	if expired, err := db.SentExpire(now.Add(time.Hour)); err != nil || expired != 1 {
+		t.Fatalf("Expected the record to expire after it was sent, expired %d (%v)", expired, err)
+	}
+}
@@ -0,0 +1,150 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/telegram/search"
+	"roob.re/wallabot/wallapop"
+)
+
+// newUserWithSearch returns a database with a user that has a search for each of the given keywords
+func newUserWithSearch(t *testing.T, keywords ...string) *database.Database {
+	db, err := database.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AssertUser(&database.User{ID: 1, Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.UserUpdate(1, func(u *database.User) error {
+		u.Searches = database.SavedSearches{}
+		for _, k := range keywords {
+			u.Searches.Set(&database.SavedSearch{Search: search.Search{Keywords: k}})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSentItems(t *testing.T) {
+	db := newUserWithSearch(t, "rtx 3080")
+
+	if written, err := db.Watch(1, "rtx 3080", "c", 500, time.Now()); err != nil || !written {
+		t.Fatalf("Expected watching c to write, got %v (%v)", written, err)
+	}
+	if written, err := db.Watch(1, "rtx 3080", "c", 500, time.Now()); err != nil || written {
+		t.Fatalf("Expected watching at the same price not to write, got %v (%v)", written, err)
+	}
+
+	err := db.MarkSent(1, &wallapop.Item{ID: "c", Price: 400}, "rtx 3080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, watched, _ := db.Watched(1, "rtx 3080", "c"); watched {
+		t.Fatalf("Expected c not to be watched once notified")
+	}
+
+	err = db.MarkSent(1, &wallapop.Item{ID: "a", Price: 10}, "rtx 3080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.MarkSent(1, &wallapop.Item{ID: "b", Title: "RTX 3080", Slug: "rtx-3080-b", Price: 20}, "rtx 3080", "deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := db.SentItem(1, "b"); err != nil || item.Title != "RTX 3080" || item.Slug != "rtx-3080-b" {
+		t.Fatalf("Expected title and slug of b to be kept, got %+v (%v)", item, err)
+	}
+
+	err = db.User(1, func(u *database.User) error {
+		if len(u.Notified) != 0 {
+			t.Fatalf("Expected sent items not to be loaded, got %v", u.Notified)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.UserWithSent(1, func(u *database.User) error {
+		ss := u.Searches.Get("rtx 3080")
+		if len(u.Notified) != 3 || len(ss.SentItems) != 3 || ss.SentItems["a"] != 10 || ss.SentItems["b"] != 20 {
+			t.Fatalf("Unexpected sent items %v, %v", u.Notified, ss.SentItems)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.UserUpdateWithSent(1, func(u *database.User) error {
+		u.Forget("a")
+		u.Searches.Delete("rtx 3080")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := db.SentCount(); err != nil || count != 0 {
+		t.Fatalf("Expected the items of the deleted search to be forgotten, got %d (%v)", count, err)
+	}
+
+	err = db.UserWithSent(1, func(u *database.User) error {
+		if _, found := u.Notified["a"]; found || len(u.Notified) != 2 {
+			t.Fatalf("Expected only b and c to be left, got %v", u.Notified)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSentExpire(t *testing.T) {
+	db := newUserWithSearch(t, "rtx")
+
+	for _, itemID := range []string{"old", "listed"} {
+		if err := db.MarkSent(1, &wallapop.Item{ID: itemID, Price: 10}, "rtx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	later := time.Now().Add(60 * 24 * time.Hour)
+	err := db.SentSeen(1, "rtx", []string{"listed", "unknown"}, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := db.SentStale(later.Add(-24*time.Hour), 10)
+	if err != nil || len(stale) != 1 || stale[0] != (database.SentRef{UserID: 1, Search: "rtx", ItemID: "old"}) {
+		t.Fatalf("Expected only old to be stale, got %v (%v)", stale, err)
+	}
+
+	expired, err := db.SentExpire(later.Add(-30 * 24 * time.Hour))
+	if err != nil || expired != 2 {
+		t.Fatalf("Expected the search and user records of old to expire, got %d (%v)", expired, err)
+	}
+
+	err = db.UserWithSent(1, func(u *database.User) error {
+		sent := u.Searches.Get("rtx").SentItems
+		if _, found := sent["old"]; found || len(sent) != 1 || len(u.Notified) != 1 {
+			t.Fatalf("Expected only listed to be kept, got %v and %v", sent, u.Notified)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Items seen above the budget of a search are stored in their own keys, watched/<user>/<search>/<item>, with a TTL so
+// the ones that stop showing up are dropped on their own
+const watchedKeyPrefix = "watched/"
+
+const (
+	// Watched items not seen again for this long are forgotten
+	watchedTTL = 30 * 24 * time.Hour
+	// Watched items seen again at the same price are refreshed at most this often, to keep them from expiring
+	watchedRefresh = 7 * 24 * time.Hour
+)
+
+// watchedRecord is the value stored for each watched item
+type watchedRecord struct {
+	Price float64
+	Seen  time.Time
+}
+
+// Watched returns the last seen price of an item above the budget of a search, if it is being watched
+func (db *Database) Watched(userID int, search, itemID string) (float64, bool, error) {
+	record, found, err := db.watched(userID, search, itemID)
+	return record.Price, found, err
+}
+
+// Watch records the last seen price of an item that is above the budget of a search. It returns whether the record
+// was written, which is only done when the price changed or the record is about to expire.
+func (db *Database) Watch(userID int, search, itemID string, price float64, now time.Time) (bool, error) {
+	record, found, err := db.watched(userID, search, itemID)
+	if err != nil {
+		return false, err
+	}
+
+	if found && record.Price == price && now.Sub(record.Seen) < watchedRefresh {
+		return false, nil
+	}
+
+	recordJson, err := json.Marshal(watchedRecord{Price: price, Seen: now})
+	if err != nil {
+		return false, fmt.Errorf("marshalling watched item into json: %w", err)
+	}
+
+	return true, db.bdg.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(watchedKey(userID, search, itemID), recordJson).WithTTL(watchedTTL))
+	})
+}
+
+// unwatch stops watching an item for the given searches, usually because it has been notified
+func unwatch(txn *badger.Txn, userID int, itemID string, searches ...string) error {
+	for _, search := range searches {
+		if err := txn.Delete(watchedKey(userID, search, itemID)); err != nil {
+			return fmt.Errorf("deleting watched item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) watched(userID int, search, itemID string) (watchedRecord, bool, error) {
+	record := watchedRecord{}
+	err := db.bdg.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(watchedKey(userID, search, itemID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &record)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, fmt.Errorf("getting watched item from DB: %w", err)
+	}
+
+	return record, true, nil
+}
+
+func watchedKey(userID int, search, itemID string) []byte {
+	return []byte(watchedKeyPrefix + fmt.Sprint(userID) + "/" + search + "/" + itemID)
+}
@@ -8,5 +8,6 @@ require (
 	github.com/prometheus/client_golang v1.11.1
 	github.com/sethgrid/pester v1.1.0
 	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/text v0.3.7
 	gopkg.in/tucnak/telebot.v2 v2.3.5
 )
@@ -8,6 +8,8 @@ import (
 	"roob.re/wallabot/database"
 	"roob.re/wallabot/search"
 	"roob.re/wallabot/telegram"
+	"roob.re/wallabot/wallapop"
+	"strconv"
 	"time"
 )
 
@@ -31,10 +33,11 @@ func (r *Reporter) ListenAndServe(address string) error {
 	return http.ListenAndServe(address, promHandler)
 }
 
-func (r *Reporter) Watch(db *database.Database, bot *telegram.Wallabot, se *search.Searcher) {
+func (r *Reporter) Watch(db *database.Database, bot *telegram.Wallabot, se *search.Searcher, wp *wallapop.Client) {
 	r.watchDBMetrics(db)
 	r.watchTelegramMetrics(bot)
 	r.watchBacklogMetrics(se)
+	r.watchWallapopMetrics(wp)
 }
 
 func (r *Reporter) watchDBMetrics(db *database.Database) {
@@ -57,16 +60,23 @@ func (r *Reporter) watchDBMetrics(db *database.Database) {
 		})
 		_ = r.registry.Register(notificationsMetric)
 
+		intervalMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wallabot_search_interval_seconds",
+			Help: "Current interval between runs of each search",
+		}, []string{"user", "search"})
+		_ = r.registry.Register(intervalMetric)
+
 		for {
 			users := 0
 			searches := 0
-			notifications := 0
+			// Deleted searches would otherwise be reported forever
+			intervalMetric.Reset()
 
 			err := db.UserEach(func(u *database.User) error {
 				users += 1
 				searches += len(u.Searches)
-				for _, s := range u.Searches {
-					notifications += len(s.SentItems)
+				for keywords, s := range u.Searches {
+					intervalMetric.WithLabelValues(strconv.Itoa(u.ID), keywords).Set(search.Interval(s).Seconds())
 				}
 
 				return nil
@@ -76,6 +86,11 @@ func (r *Reporter) watchDBMetrics(db *database.Database) {
 				log.Warnf("Error while gathering metrics from database: %v", err)
 			}
 
+			notifications, err := db.SentCount()
+			if err != nil {
+				log.Warnf("Error while counting notified items: %v", err)
+			}
+
 			usersMetric.Set(float64(users))
 			searchesMetric.Set(float64(searches))
 			notificationsMetric.Set(float64(notifications))
@@ -132,3 +147,85 @@ func (r *Reporter) watchBacklogMetrics(searcher *search.Searcher) {
 		}
 	}()
 }
+
+func (r *Reporter) watchWallapopMetrics(wp *wallapop.Client) {
+	go func() {
+		blockedMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wallabot_wallapop_blocked",
+			Help: "Whether requests to Wallapop are paused because it is blocking us",
+		})
+		_ = r.registry.Register(blockedMetric)
+
+		_ = r.registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wallabot_wallapop_cache_hits_total",
+			Help: "Number of search pages served from the cache",
+		}, func() float64 {
+			hits, _ := wp.CacheStats()
+			return float64(hits)
+		}))
+
+		_ = r.registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wallabot_wallapop_cache_misses_total",
+			Help: "Number of search pages not found in the cache, and requested to Wallapop",
+		}, func() float64 {
+			_, misses := wp.CacheStats()
+			return float64(misses)
+		}))
+
+		for _, kind := range wallapop.ErrorKinds {
+			kind := kind
+			_ = r.registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "wallabot_wallapop_errors_total",
+				Help:        "Number of failed requests to Wallapop by kind of error, blocked ones were refused without being sent",
+				ConstLabels: prometheus.Labels{"kind": kind},
+			}, func() float64 {
+				return float64(wp.ErrorStats()[kind])
+			}))
+		}
+
+		proxyHealthyMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wallabot_wallapop_proxy_healthy",
+			Help: "Whether a proxy is in rotation, the direct connection is reported with an empty proxy",
+		}, []string{"proxy"})
+		_ = r.registry.Register(proxyHealthyMetric)
+
+		// Proxies are set before metrics are watched, and do not change afterwards
+		for i, ps := range wp.ProxyStats() {
+			i := i
+			_ = r.registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "wallabot_wallapop_proxy_requests_total",
+				Help:        "Number of requests made through a proxy",
+				ConstLabels: prometheus.Labels{"proxy": ps.Proxy},
+			}, func() float64 {
+				return float64(wp.ProxyStats()[i].Requests)
+			}))
+
+			_ = r.registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "wallabot_wallapop_proxy_errors_total",
+				Help:        "Number of requests through a proxy that failed or were refused by Wallapop",
+				ConstLabels: prometheus.Labels{"proxy": ps.Proxy},
+			}, func() float64 {
+				return float64(wp.ProxyStats()[i].Errors)
+			}))
+		}
+
+		for {
+			blocked, _ := wp.Blocked()
+			if blocked {
+				blockedMetric.Set(1)
+			} else {
+				blockedMetric.Set(0)
+			}
+
+			for _, ps := range wp.ProxyStats() {
+				healthy := 0.0
+				if ps.Healthy {
+					healthy = 1
+				}
+				proxyHealthyMetric.WithLabelValues(ps.Proxy).Set(healthy)
+			}
+
+			time.Sleep(r.Interval / 4)
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package search
+
+import (
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+const (
+	// Default bounds for the interval of searches that do not set their own
+	defaultMinInterval = 10 * time.Minute
+	defaultMaxInterval = 6 * time.Hour
+
+	// Weight of the latest run in the average listing rate of a search
+	rateSmoothing = 0.3
+	// Searches are run often enough to find about this many new items each time
+	newItemsPerRun = 1.0
+)
+
+// adapt updates the listing rate of a search with the number of new items found by a run started at ranAt, and
+// schedules the next run accordingly. It also remembers the items the run saw, so the next can stop paging at them.
+func (s *Searcher) adapt(j job, items []wallapop.Item, newItems int, ranAt time.Time) {
+	err := s.db.UserUpdate(j.user.ID, func(u *database.User) error {
+		ss := u.Searches.Get(j.savedSearch.Search.Keywords)
+		if ss == nil {
+			return nil
+		}
+
+		// The first run finds every listing already there, which says nothing about how often new ones appear
+		if !ss.LastRun.IsZero() {
+			hours := ranAt.Sub(ss.LastRun).Hours()
+			if hours > 0 {
+				ss.Rate = rateSmoothing*float64(newItems)/hours + (1-rateSmoothing)*ss.Rate
+			}
+
+			ss.Cadence = s.cadence(ss)
+			if ss.Search.Every == 0 {
+				ss.NextRun = ranAt.Add(ss.Cadence)
+			}
+		}
+
+		ss.LastRun = ranAt
+		ss.LastSeen = make([]string, 0, len(items))
+		for _, item := range items {
+			ss.LastSeen = append(ss.LastSeen, item.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "search",
+		}).Errorf("Error updating listing rate of %q: %v", j.savedSearch.Search.Keywords, err)
+	}
+}
+
+// cadence returns how often a search should run given its listing rate. It shortens as soon as items appear more
+// often, but lengthens at most twofold per run so a few quiet runs do not send a search to the bottom.
+func (s *Searcher) cadence(ss *database.SavedSearch) time.Duration {
+	current := ss.Cadence
+	if current == 0 {
+		current = defaultSearchInterval
+	}
+
+	next := s.MaxInterval
+	if ss.Rate > 0 {
+		next = time.Duration(math.Min(newItemsPerRun/ss.Rate*float64(time.Hour), float64(s.MaxInterval)))
+	}
+
+	if next > 2*current {
+		next = 2 * current
+	}
+
+	if next < s.MinInterval {
+		next = s.MinInterval
+	}
+	if next > s.MaxInterval {
+		next = s.MaxInterval
+	}
+
+	return next.Round(time.Minute)
+}
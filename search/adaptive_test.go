@@ -0,0 +1,29 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"roob.re/wallabot/database"
+)
+
+func TestSearcher_cadence(t *testing.T) {
+	s := &Searcher{MinInterval: 10 * time.Minute, MaxInterval: 6 * time.Hour}
+
+	for _, tc := range []struct {
+		rate     float64
+		current  time.Duration
+		expected time.Duration
+	}{
+		{rate: 2, current: 30 * time.Minute, expected: 30 * time.Minute},
+		{rate: 60, current: 30 * time.Minute, expected: 10 * time.Minute},
+		{rate: 0.5, current: 30 * time.Minute, expected: time.Hour},
+		{rate: 0, current: 4 * time.Hour, expected: 6 * time.Hour},
+		{rate: 0, current: 0, expected: time.Hour},
+	} {
+		ss := &database.SavedSearch{Rate: tc.rate, Cadence: tc.current}
+		if actual := s.cadence(ss); actual != tc.expected {
+			t.Fatalf("Rate %.1f from %v: expected %v, got %v", tc.rate, tc.current, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+// retryDelay is how soon a search that failed for a transient reason is retried, unless Wallapop says otherwise
+const retryDelay = 5 * time.Minute
+
+// handleSearchError logs a failed search according to what went wrong, and reschedules it sooner if it is worth
+// retrying before its next regular run
+func (s *Searcher) handleSearchError(j job, err error) {
+	logger := log.WithFields(log.Fields{
+		"component": "search",
+		"user":      j.user.ID,
+		"search":    j.savedSearch.Search.Keywords,
+	})
+
+	var rateLimited *wallapop.ErrRateLimited
+	var forbidden *wallapop.ErrForbidden
+	var unavailable *wallapop.ErrUpstreamUnavailable
+	var signature *wallapop.ErrSignatureRejected
+	var decode *wallapop.ErrDecode
+
+	switch {
+	case errors.As(err, &rateLimited):
+		retry := retryDelay
+		if rateLimited.RetryAfter > retry {
+			retry = rateLimited.RetryAfter
+		}
+		logger.Warnf("Rate limited by Wallapop, retrying in %v", retry)
+		s.retryIn(j, retry)
+	case errors.As(err, &forbidden), errors.As(err, &unavailable):
+		logger.Warnf("Wallapop unavailable, retrying in %v: %v", retryDelay, err)
+		s.retryIn(j, retryDelay)
+	case errors.As(err, &signature):
+		logger.Errorf("Wallapop rejected our request signature, the signing key may have changed: %v", err)
+	case errors.As(err, &decode):
+		logger.Errorf("Could not understand Wallapop response, the API may have changed: %v", err)
+	default:
+		logger.Errorf("Error processing backlog search: %v", err)
+	}
+}
+
+// retryIn moves the next run of a search earlier, so it is retried after the given delay
+func (s *Searcher) retryIn(j job, delay time.Duration) {
+	next := time.Now().Add(delay)
+
+	err := s.db.UserUpdate(j.user.ID, func(u *database.User) error {
+		ss := u.Searches.Get(j.savedSearch.Search.Keywords)
+		if ss == nil || ss.NextRun.Before(next) {
+			return nil
+		}
+
+		ss.NextRun = next
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "search",
+		}).Errorf("Error rescheduling search %q for user %d: %v", j.savedSearch.Search.Keywords, j.user.ID, err)
+	}
+}
@@ -0,0 +1,175 @@
+package search
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+// Notified items that have not shown up in search results nor been checked for this long are checked by the sweep
+const recheckSweepInterval = 24 * time.Hour
+
+// The sweep checks at most this many items, one at a time, so it does not use up the requests searches need
+const (
+	recheckSweepMax  = 500
+	recheckSweepPace = time.Minute
+)
+
+// Items are not checked more often than this, no matter how many times they go missing from results
+const recheckMinInterval = 6 * time.Hour
+
+type recheck struct {
+	user   *database.User
+	search string
+	itemID string
+}
+
+// sweepSentItems periodically queues the notified items that have not been seen for the longest to be checked for
+// availability, a few at a time
+func (s *Searcher) sweepSentItems() {
+	for {
+		time.Sleep(recheckSweepInterval)
+
+		stale, err := s.db.SentStale(time.Now().Add(-recheckSweepInterval), recheckSweepMax)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "recheck",
+			}).Errorf("Error looking for notified items to check: %v", err)
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"component": "recheck",
+		}).Infof("Queuing %d notified items for availability check", len(stale))
+
+		users := map[int]*database.User{}
+		for _, ref := range stale {
+			u, found := users[ref.UserID]
+			if !found {
+				err := s.db.User(ref.UserID, func(user *database.User) error {
+					u = user
+					return nil
+				})
+				if err != nil {
+					log.WithFields(log.Fields{
+						"component": "recheck",
+					}).Errorf("Error getting user %d: %v", ref.UserID, err)
+				}
+				users[ref.UserID] = u
+			}
+
+			if u == nil {
+				continue
+			}
+
+			s.rechecks <- recheck{user: u, search: ref.Search, itemID: ref.ItemID}
+			time.Sleep(recheckSweepPace)
+		}
+	}
+}
+
+// queueMissing queues notified items of a search that were not present in its latest results.
+// Results come newest first and paging stops early when there is nothing new, so only items that were found by the
+// previous run between ones found again are known to be missing. The rest are left for the periodic sweep.
+// Items are dropped if the recheck queue is full, as they will be picked up by the next run or sweep anyway.
+func (s *Searcher) queueMissing(j job, items []wallapop.Item) {
+	previous := make(map[string]int, len(j.savedSearch.LastSeen))
+	for i, itemID := range j.savedSearch.LastSeen {
+		previous[itemID] = i
+	}
+
+	seen := make(map[string]bool, len(items))
+	reached := -1
+	for _, item := range items {
+		seen[item.ID] = true
+		if i, found := previous[item.ID]; found && i > reached {
+			reached = i
+		}
+	}
+
+	for _, itemID := range j.savedSearch.LastSeen[:reached+1] {
+		if _, sent := j.savedSearch.SentItems[itemID]; !sent || seen[itemID] {
+			continue
+		}
+
+		select {
+		case s.rechecks <- recheck{user: j.user, search: j.savedSearch.Search.Keywords, itemID: itemID}:
+		default:
+			return
+		}
+	}
+}
+
+// consumeRechecks looks up queued items, and notifies when they have been sold, reserved or deleted
+func (s *Searcher) consumeRechecks() {
+	checked := map[string]time.Time{}
+	lastPrune := time.Now()
+
+	for rc := range s.rechecks {
+		if time.Since(lastPrune) > recheckMinInterval {
+			for itemID, t := range checked {
+				if time.Since(t) > recheckMinInterval {
+					delete(checked, itemID)
+				}
+			}
+			lastPrune = time.Now()
+		}
+
+		if time.Since(checked[rc.itemID]) < recheckMinInterval {
+			continue
+		}
+
+		s.waitUnblocked()
+		checked[rc.itemID] = time.Now()
+
+		item, err := s.wp.Item(rc.itemID)
+		var status database.ItemStatus
+		switch {
+		case errors.Is(err, wallapop.ErrItemNotFound):
+			// Deleted items cannot be looked up anymore, tell the user about them as they were notified
+			item, err = s.db.SentItem(rc.user.ID, rc.itemID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "recheck",
+				}).Warnf("Could not get notified item '%s': %v", rc.itemID, err)
+				item = &wallapop.Item{ID: rc.itemID}
+			}
+			status = database.ItemDeleted
+		case err != nil:
+			log.WithFields(log.Fields{
+				"component": "recheck",
+			}).Errorf("Error checking availability of '%s': %v", rc.itemID, err)
+		case item.Flags.Sold:
+			status = database.ItemSold
+		case item.Flags.Reserved:
+			status = database.ItemReserved
+		case !item.Available():
+			status = database.ItemDeleted
+		}
+
+		if err == nil && status == "" {
+			err = s.db.SentSeen(rc.user.ID, rc.search, []string{rc.itemID}, time.Now())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "recheck",
+				}).Errorf("Error refreshing '%s' for %q: %v", rc.itemID, rc.search, err)
+			}
+		}
+
+		if status != "" {
+			log.WithFields(log.Fields{
+				"component": "recheck",
+			}).Debugf("Item '%s' for %q is %s, queuing notification", rc.itemID, rc.search, status)
+
+			s.notifier <- database.Notification{
+				User:   rc.user,
+				Item:   item,
+				Search: rc.search,
+				Gone:   status,
+			}
+		}
+	}
+}
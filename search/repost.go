@@ -0,0 +1,111 @@
+package search
+
+import (
+	"image"
+	"math/bits"
+	"strings"
+	"time"
+
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+// Pictures whose hashes differ in at most this many bits are considered the same
+const maxHashDistance = 6
+
+// dHash computes the difference hash of an image: it is shrunk to 9x8 gray cells, and each bit tells whether a cell
+// is brighter than the one to its right. It survives rescaling, recompression and small color changes.
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+
+	var cells [h][w]float64
+	var counts [h][w]int
+
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * w / b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			cells[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if counts[y][x] == 0 || counts[y][x+1] == 0 {
+				continue
+			}
+
+			if cells[y][x]/float64(counts[y][x]) > cells[y][x+1]/float64(counts[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// sameListing returns whether two fingerprints from the same seller look like the same item listed twice
+func sameListing(a, b database.Fingerprint) bool {
+	if bits.OnesCount64(a.Hash^b.Hash) > maxHashDistance {
+		return false
+	}
+
+	// Sellers with many items may reuse a background or a product shot, so the picture alone is not enough
+	return strings.EqualFold(strings.TrimSpace(a.Title), strings.TrimSpace(b.Title)) || a.Price == b.Price
+}
+
+// fingerprint returns the fingerprint of an item, computing and storing it if it was not seen before.
+// The returned fingerprint has RepostOf set if the item looks like a repost of an earlier listing of the seller.
+func (s *Searcher) fingerprint(item *wallapop.Item) (*database.Fingerprint, error) {
+	if item.Seller.ID == "" || len(item.Images) == 0 {
+		return nil, nil
+	}
+
+	known, err := s.db.Fingerprints(item.Seller.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range known {
+		if known[i].ItemID == item.ID {
+			return &known[i], nil
+		}
+	}
+
+	img, err := s.wp.Image(item.Images[0].Thumbnail())
+	if err != nil {
+		return nil, err
+	}
+
+	f := database.Fingerprint{
+		ItemID:    item.ID,
+		Hash:      dHash(img),
+		Title:     item.Title,
+		Price:     item.Price,
+		FirstSeen: time.Now(),
+	}
+
+	for _, other := range known {
+		if !sameListing(f, other) || !other.FirstSeen.Before(f.FirstSeen) {
+			continue
+		}
+
+		// Point to the earliest listing, so a chain of reposts is dated from the first one
+		f.FirstSeen = other.FirstSeen
+		f.RepostOf = other.ItemID
+		if other.RepostOf != "" {
+			f.RepostOf = other.RepostOf
+		}
+	}
+
+	return &f, s.db.FingerprintSave(item.Seller.ID, f)
+}
@@ -0,0 +1,38 @@
+package search
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// waves returns a w by h image with a smooth pattern that does not depend on its size, inverted if reversed
+func waves(w, h int, reversed bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 0.5 + 0.5*math.Sin(3*math.Pi*fx+2*math.Pi*fy)*math.Cos(2*math.Pi*fy)
+			if reversed {
+				v = 1 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v * 255)})
+		}
+	}
+
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	original := dHash(waves(320, 240, false))
+
+	if d := bits.OnesCount64(original ^ dHash(waves(160, 120, false))); d > maxHashDistance {
+		t.Fatalf("Rescaled image differs in %d bits", d)
+	}
+
+	if d := bits.OnesCount64(original ^ dHash(waves(320, 240, true))); d <= maxHashDistance {
+		t.Fatalf("Different image differs in only %d bits", d)
+	}
+}
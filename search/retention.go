@@ -0,0 +1,69 @@
+package search
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/wallapop"
+)
+
+const (
+	defaultSentRetention = 90 * 24 * time.Hour
+	sentExpiryInterval   = 6 * time.Hour
+)
+
+// expireSentItems periodically forgets notified items that have not shown up in the results of any search for longer
+// than SentRetention
+func (s *Searcher) expireSentItems() {
+	for {
+		time.Sleep(sentExpiryInterval)
+
+		if s.SentRetention == 0 {
+			continue
+		}
+
+		expired, err := s.db.SentExpire(time.Now().Add(-s.SentRetention))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "search",
+			}).Errorf("Error expiring notified items: %v", err)
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"component": "search",
+		}).Infof("Forgot %d notified items not seen in %v", expired, s.SentRetention)
+	}
+}
+
+// markSeen refreshes the records of notified items found again by a search, either in its results or among the known
+// items paging stopped at, so they are kept while they show up
+func (s *Searcher) markSeen(j job, items []wallapop.Item, known []string) {
+	ids := make([]string, 0, len(items)+len(known))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	ids = append(ids, known...)
+
+	var notified []string
+	marked := make(map[string]bool, len(ids))
+	for _, itemID := range ids {
+		if marked[itemID] || !j.sent(itemID) {
+			continue
+		}
+
+		marked[itemID] = true
+		notified = append(notified, itemID)
+	}
+
+	if len(notified) == 0 {
+		return
+	}
+
+	err := s.db.SentSeen(j.user.ID, j.savedSearch.Search.Keywords, notified, time.Now())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "search",
+		}).Errorf("Error refreshing notified items for %q: %v", j.savedSearch.Search.Keywords, err)
+	}
+}
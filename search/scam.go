@@ -0,0 +1,250 @@
+package search
+
+import (
+	"image"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+	searchcmd "roob.re/wallabot/telegram/search"
+	"roob.re/wallabot/wallapop"
+)
+
+// Items scoring this or more are considered likely scams
+const scamThreshold = 1.0
+
+// Heuristics that make requests remember this many of their results at most
+const heuristicCacheSize = 4096
+
+// Heuristics that make requests are run on this many items per search run at most, as requests share the rate limit
+// with the searches of every user. Further items are scored by the other heuristics only.
+const maxRemoteScoredPerRun = 3
+
+// Heuristic scores how likely an item is to be a scam or spam.
+// It returns 0 if nothing suspicious was found, and a short human-readable reason otherwise.
+type Heuristic interface {
+	Score(item *wallapop.Item, market *database.Market) (float64, string)
+}
+
+// HeuristicFunc adapts a function to the Heuristic interface
+type HeuristicFunc func(item *wallapop.Item, market *database.Market) (float64, string)
+
+func (f HeuristicFunc) Score(item *wallapop.Item, market *database.Market) (float64, string) {
+	return f(item, market)
+}
+
+// remoteHeuristic marks heuristics that make requests to Wallapop
+type remoteHeuristic struct {
+	Heuristic
+}
+
+// scamScore runs the heuristics on an item and returns the total score and the reasons for it. Heuristics that make
+// requests are skipped unless remote is set.
+func (s *Searcher) scamScore(item *wallapop.Item, market *database.Market, remote bool) (float64, []string) {
+	var total float64
+	var reasons []string
+	for _, h := range s.Heuristics {
+		if _, isRemote := h.(remoteHeuristic); isRemote && !remote {
+			continue
+		}
+
+		score, reason := h.Score(item, market)
+		if score > 0 {
+			total += score
+			reasons = append(reasons, reason)
+		}
+	}
+
+	return total, reasons
+}
+
+// DefaultHeuristics returns the heuristics used by the searcher unless told otherwise
+func DefaultHeuristics(wp *wallapop.Client) []Heuristic {
+	return []Heuristic{
+		HeuristicFunc(underpriced),
+		HeuristicFunc(scamPhrases),
+		HeuristicFunc(contactDetails),
+		remoteHeuristic{newSellers(wp)},
+		remoteHeuristic{stockPhotos(wp)},
+	}
+}
+
+// underpriced flags items priced far below what the search usually sees
+func underpriced(item *wallapop.Item, market *database.Market) (float64, string) {
+	const maxBelow = 0.6
+
+	if market.BelowMedian(item.Price) >= maxBelow {
+		return 0.6, "price far below the usual"
+	}
+
+	return 0, ""
+}
+
+var scamPhraseList = searchcmd.NewExclusions([]string{
+	"solo envio", "solo envios", "solo por envio", "envio solo",
+	"whatsapp", "wasap", "whats app", "telegram", "bizum", "western union", "paypal amigos",
+})
+
+// scamPhrases flags items asking to move the conversation or payment out of Wallapop
+func scamPhrases(item *wallapop.Item, _ *database.Market) (float64, string) {
+	if scamPhraseList.Match(item.Title + "\n" + item.Description) {
+		return 1, "asks to deal outside Wallapop"
+	}
+
+	return 0, ""
+}
+
+var (
+	emailRegex = regexp.MustCompile(`(?i)[a-z0-9._%+-]+\s*(@|\(at\)|\[at\])\s*[a-z0-9.-]+\.[a-z]{2,}`)
+	phoneRegex = regexp.MustCompile(`(?:(?:\+|00)\s*34[\s.-]*)?\b[6789](?:[\s.-]?\d){8}\b`)
+)
+
+// contactDetails flags items with an email address or phone number in the description
+func contactDetails(item *wallapop.Item, _ *database.Market) (float64, string) {
+	text := item.Title + "\n" + item.Description
+	if emailRegex.MatchString(text) || phoneRegex.MatchString(text) {
+		return 1, "contact details in description"
+	}
+
+	return 0, ""
+}
+
+// newSellers flags items from sellers who registered very recently
+func newSellers(wp *wallapop.Client) Heuristic {
+	const minAge = 7 * 24 * time.Hour
+
+	registered := newBoundedCache(heuristicCacheSize)
+
+	return HeuristicFunc(func(item *wallapop.Item, _ *database.Market) (float64, string) {
+		if item.Seller.ID == "" {
+			return 0, ""
+		}
+
+		cached, known := registered.get(item.Seller.ID)
+		since, _ := cached.(time.Time)
+		if !known {
+			seller, err := wp.Seller(item.Seller.ID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "search",
+				}).Debugf("Could not get profile of seller '%s': %v", item.Seller.ID, err)
+				return 0, ""
+			}
+
+			since = seller.Registered
+			registered.put(item.Seller.ID, since)
+		}
+
+		if time.Since(since) < minAge {
+			return 0.5, "brand-new seller"
+		}
+
+		return 0, ""
+	})
+}
+
+// stockPhotos flags items whose main picture looks like a product shot on a plain white background, as taken from
+// a shop or the manufacturer rather than by the seller
+func stockPhotos(wp *wallapop.Client) Heuristic {
+	const minWhiteBorder = 0.9
+
+	// By item, whether its picture looks like a stock photo
+	stock := newBoundedCache(heuristicCacheSize)
+
+	return HeuristicFunc(func(item *wallapop.Item, _ *database.Market) (float64, string) {
+		if len(item.Images) == 0 {
+			return 0, ""
+		}
+
+		cached, known := stock.get(item.ID)
+		isStock, _ := cached.(bool)
+		if !known {
+			img, err := wp.Image(item.Images[0].Thumbnail())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "search",
+				}).Debugf("Could not get picture of '%s': %v", item.ID, err)
+				return 0, ""
+			}
+
+			isStock = whiteBorder(img) >= minWhiteBorder
+			stock.put(item.ID, isStock)
+		}
+
+		if isStock {
+			return 0.5, "looks like a stock photo"
+		}
+
+		return 0, ""
+	})
+}
+
+// boundedCache remembers up to a number of values, forgetting the oldest ones first
+type boundedCache struct {
+	mtx    sync.Mutex
+	size   int
+	values map[string]interface{}
+	order  []string // Keys, oldest first
+}
+
+func newBoundedCache(size int) *boundedCache {
+	return &boundedCache{
+		size:   size,
+		values: make(map[string]interface{}, size),
+	}
+}
+
+func (c *boundedCache) get(key string) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	value, found := c.values[key]
+	return value, found
+}
+
+func (c *boundedCache) put(key string, value interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, found := c.values[key]; !found {
+		c.order = append(c.order, key)
+	}
+	c.values[key] = value
+
+	for len(c.order) > c.size {
+		delete(c.values, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// whiteBorder returns the fraction of pixels in the border of an image that are almost white
+func whiteBorder(img image.Image) float64 {
+	const nearWhite = 0xf000 // Out of 0xffff
+
+	b := img.Bounds()
+	if b.Dx() < 3 || b.Dy() < 3 {
+		return 0
+	}
+
+	var total, white int
+	check := func(x, y int) {
+		r, g, bl, _ := img.At(x, y).RGBA()
+		total++
+		if r >= nearWhite && g >= nearWhite && bl >= nearWhite {
+			white++
+		}
+	}
+
+	for x := b.Min.X; x < b.Max.X; x++ {
+		check(x, b.Min.Y)
+		check(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		check(b.Min.X, y)
+		check(b.Max.X-1, y)
+	}
+
+	return float64(white) / float64(total)
+}
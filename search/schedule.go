@@ -0,0 +1,146 @@
+package search
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+)
+
+// Searches without an explicit interval are run this often
+const defaultSearchInterval = 30 * time.Minute
+
+// The schedule is rebuilt from the database this often, to pick up searches created, changed or deleted since
+const scheduleReloadInterval = time.Minute
+
+// scheduled is a saved search waiting for its next run
+type scheduled struct {
+	userID int
+	search string
+	next   time.Time
+}
+
+// schedule is a priority queue of saved searches, earliest next run first
+type schedule []scheduled
+
+func (s schedule) Len() int            { return len(s) }
+func (s schedule) Less(i, j int) bool  { return s[i].next.Before(s[j].next) }
+func (s schedule) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x interface{}) { *s = append(*s, x.(scheduled)) }
+func (s *schedule) Pop() interface{} {
+	old := *s
+	last := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return last
+}
+
+// Interval returns how often a saved search should be run
+func Interval(ss *database.SavedSearch) time.Duration {
+	if ss.Search.Every != 0 {
+		return time.Duration(ss.Search.Every)
+	}
+
+	if ss.Cadence != 0 {
+		return ss.Cadence
+	}
+
+	return defaultSearchInterval
+}
+
+// loadSchedule builds the schedule from the next run times persisted for every saved search
+func (s *Searcher) loadSchedule() (*schedule, error) {
+	queue := &schedule{}
+	err := s.db.UserEach(func(u *database.User) error {
+		for keywords, ss := range u.Searches {
+			*queue = append(*queue, scheduled{userID: u.ID, search: keywords, next: ss.NextRun})
+		}
+
+		return nil
+	})
+	heap.Init(queue)
+
+	return queue, err
+}
+
+// runSchedule queues saved searches for the workers as they become due, and persists when they should run next
+func (s *Searcher) runSchedule() {
+	var queue *schedule
+	var lastLoad time.Time
+
+	for {
+		if time.Since(lastLoad) >= scheduleReloadInterval {
+			var err error
+			queue, err = s.loadSchedule()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "search",
+				}).Errorf("Error loading search schedule: %v", err)
+			}
+			lastLoad = time.Now()
+		}
+
+		wait := scheduleReloadInterval - time.Since(lastLoad)
+		if queue.Len() > 0 {
+			if untilNext := time.Until((*queue)[0].next); untilNext < wait {
+				wait = untilNext
+			}
+		}
+
+		if wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+
+		due := heap.Pop(queue).(scheduled)
+		j, next, err := s.dispatch(due)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "search",
+			}).Errorf("Error scheduling search %q for user %d: %v", due.search, due.userID, err)
+			continue
+		}
+
+		// Deleted since the schedule was loaded
+		if j == nil {
+			continue
+		}
+
+		heap.Push(queue, scheduled{userID: due.userID, search: due.search, next: next})
+		s.backlog <- *j
+	}
+}
+
+// dispatch persists the next run time of a due search, and returns the job to run it now.
+// It returns a nil job if the search no longer exists.
+func (s *Searcher) dispatch(due scheduled) (*job, time.Time, error) {
+	var j *job
+	var next time.Time
+
+	err := s.db.UserUpdate(due.userID, func(u *database.User) error {
+		ss := u.Searches.Get(due.search)
+		if ss == nil {
+			return nil
+		}
+
+		next = time.Now().Add(Interval(ss))
+		ss.NextRun = next
+
+		ss.LegacyFill()
+		j = &job{user: u, savedSearch: ss}
+
+		return nil
+	})
+	if err != nil || j == nil {
+		return j, next, err
+	}
+
+	// Sent items are read apart from the update, so notifying about items meanwhile does not make it conflict
+	err = s.db.LoadSent(j.user)
+	if err != nil {
+		return nil, next, fmt.Errorf("loading sent items: %w", err)
+	}
+
+	return j, next, nil
+}
@@ -1,22 +1,36 @@
 package search
 
 import (
-	"math/rand"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"roob.re/wallabot/database"
+	searchcmd "roob.re/wallabot/telegram/search"
 	"roob.re/wallabot/wallapop"
 )
 
-const batchSearchInterval = 30 * time.Minute
 const workers = 2
 
+// Items up to this factor above MaxPrice are fetched too, so price drops into budget can be detected
+const overBudgetFactor = 1.25
+
 type Searcher struct {
 	db       *database.Database
 	wp       *wallapop.Client
 	notifier chan<- database.Notification
 	backlog  chan job
+	rechecks chan recheck
+	shared   *sharedQueries
+
+	// Heuristics score items for searches that want scams flagged or hidden
+	Heuristics []Heuristic
+
+	// Bounds for the interval of searches that adapt it to how often new items show up
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// How long notified items are remembered after they stop showing up in results, forever if zero
+	SentRetention time.Duration
 }
 
 type job struct {
@@ -24,65 +38,46 @@ type job struct {
 	savedSearch *database.SavedSearch
 }
 
+// sent returns whether the user has been notified about an item, by this search or any other
+func (j job) sent(itemID string) bool {
+	_, sent := j.savedSearch.SentItems[itemID]
+	_, notified := j.user.Notified[itemID]
+	return sent || notified
+}
+
 func New(db *database.Database, wp *wallapop.Client, notifier chan<- database.Notification) *Searcher {
 	return &Searcher{
 		db:       db,
 		wp:       wp,
 		notifier: notifier,
 		backlog:  make(chan job, 128),
+		rechecks: make(chan recheck, 256),
+		shared:   newSharedQueries(wp),
+
+		Heuristics: DefaultHeuristics(wp),
+
+		MinInterval: defaultMinInterval,
+		MaxInterval: defaultMaxInterval,
+
+		SentRetention: defaultSentRetention,
 	}
 }
 
 func (s *Searcher) Start() {
-	go s.fillBacklog()
+	go s.runSchedule()
 	for i := 0; i < workers; i++ {
 		go s.consumeBacklog()
 	}
-}
-
-func (s *Searcher) fillBacklog() {
-	var lastFill time.Time
-
-	for {
-		time.Sleep(batchSearchInterval - time.Since(lastFill))
-
-		jobs := make([]job, 0, 64)
-
-		log.WithFields(log.Fields{
-			"component": "search",
-		}).Infof("Starting to gather search jobs...")
-
-		_ = s.db.UserEach(func(u *database.User) error {
-			for _, ss := range u.Searches {
-				ss.LegacyFill()
-				jobs = append(jobs, job{
-					user:        u,
-					savedSearch: ss,
-				})
-			}
-
-			return nil
-		})
-
-		log.WithFields(log.Fields{
-			"component": "search",
-		}).Infof("Gathered %d backlog jobs, queuing...\n", len(jobs))
-
-		rand.Shuffle(len(jobs), func(i, j int) { jobs[i], jobs[j] = jobs[j], jobs[i] })
-		for _, job := range jobs {
-			s.backlog <- job
-		}
-
-		log.WithFields(log.Fields{
-			"component": "search",
-		}).Infof("%d jobs queued\n", len(jobs))
 
-		lastFill = time.Now()
-	}
+	go s.sweepSentItems()
+	go s.consumeRechecks()
+	go s.expireSentItems()
 }
 
 func (s *Searcher) consumeBacklog() {
 	for job := range s.backlog {
+		s.waitUnblocked()
+
 		// Get search radius, and user radius as a fallback
 		if job.savedSearch.Search.RadiusKm == 0 {
 			job.savedSearch.Search.RadiusKm = job.user.RadiusKm
@@ -92,36 +87,161 @@ func (s *Searcher) consumeBacklog() {
 		args := job.savedSearch.Search.Args()
 		args.Latitude = lat
 		args.Longitude = long
+		maxPrice := int(float64(job.savedSearch.Search.MaxPrice) * overBudgetFactor)
+		args.MaxPrice = maxPrice
+
+		// Newest first, so paging can stop as soon as there is nothing new
+		args.OrderBy = "newest"
+		lastSeen := make(map[string]bool, len(job.savedSearch.LastSeen))
+		for _, id := range job.savedSearch.LastSeen {
+			lastSeen[id] = true
+		}
+		// Known items are still listed even if paging stops at them, or they are filtered out of the results
+		var known []string
+		args.Known = func(item *wallapop.Item) bool {
+			_, sent := job.savedSearch.SentItems[item.ID]
+			if sent || lastSeen[item.ID] {
+				known = append(known, item.ID)
+				return true
+			}
+			return false
+		}
+
+		ranAt := time.Now()
+		// Half the interval, so equivalent searches running at different times still share most of their queries
+		items, err := s.shared.Search(args, Interval(job.savedSearch)/2)
+		if err != nil {
+			s.handleSearchError(job, err)
+			continue
+		}
 
-		items, err := s.wp.Search(args)
+		err = s.db.RecordPrices(items, time.Now())
 		if err != nil {
 			log.WithFields(log.Fields{
 				"component": "search",
-			}).Errorf("Error processing backlog search %q for user %d: %v", job.savedSearch.Search.Keywords, job.user.ID, err)
-			continue
+			}).Errorf("Error recording prices for %q: %v", job.savedSearch.Search.Keywords, err)
+		}
+
+		s.queueMissing(job, items)
+		s.markSeen(job, items, known)
+
+		market, err := s.db.MarketUpdate(job.user.ID, job.savedSearch.Search.Keywords, items, time.Now())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "search",
+			}).Errorf("Error updating price distribution for %q: %v", job.savedSearch.Search.Keywords, err)
+			market = &database.Market{}
 		}
 
+		var maxPercentilePrice float64
+		if p := job.savedSearch.Search.Percentile; p != 0 && market.Meaningful() {
+			maxPercentilePrice = market.Percentile(float64(p))
+		}
+
+		exclusions := searchcmd.NewExclusions(job.user.Excluded)
+		newItems := 0
+		remoteScored := 0
+
 		for i := range items {
 			item := &items[i]
-			if int(item.Price) > job.savedSearch.Search.MaxPrice {
+			if int(item.Price) > maxPrice {
 				continue
 			}
 
+			// Reserved items still show up in results, but the user can't buy them
+			if !item.Available() {
+				continue
+			}
+
+			if exclusions.Match(item.Title+"\n"+item.Description) || job.user.BlocksSeller(item) {
+				continue
+			}
+
+			if maxPercentilePrice != 0 && item.Price > maxPercentilePrice {
+				continue
+			}
+
+			var firstSeen time.Time
+			// Items already notified were fingerprinted back then, and it takes downloading their picture
+			if reposts := job.savedSearch.Search.Reposts; job.savedSearch.Search.CheckReposts() && !job.sent(item.ID) {
+				f, err := s.fingerprint(item)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"component": "search",
+					}).Debugf("Could not fingerprint '%s': %v", item.ID, err)
+				}
+
+				if f != nil && f.RepostOf != "" {
+					if reposts == searchcmd.RepostsHide {
+						log.WithFields(log.Fields{
+							"component": "search",
+						}).Debugf("Hiding '%s' for %q as a repost of '%s'", item.ID, job.savedSearch.Search.Keywords, f.RepostOf)
+						continue
+					}
+
+					firstSeen = f.FirstSeen
+				}
+			}
+
+			// Items already notified were scored back then, and items over the budget are only watched, so neither is
+			// worth the requests it takes to score them
+			var warnings []string
+			scam := job.savedSearch.Search.Scam
+			if scam != searchcmd.ScamOff && !job.sent(item.ID) && int(item.Price) <= job.savedSearch.Search.MaxPrice {
+				remote := remoteScored < maxRemoteScoredPerRun
+				if remote {
+					remoteScored++
+				}
+
+				score, reasons := s.scamScore(item, market, remote)
+				if score >= scamThreshold {
+					if scam == searchcmd.ScamHide {
+						log.WithFields(log.Fields{
+							"component": "search",
+						}).Debugf("Hiding '%s' for %q as a likely scam: %v", item.ID, job.savedSearch.Search.Keywords, reasons)
+						continue
+					}
+
+					warnings = reasons
+				}
+			}
+
+			// Items seen by the previous run may be waiting in a digest or quiet queue, or be price drops of known ones
+			if !job.sent(item.ID) && !lastSeen[item.ID] {
+				newItems++
+			}
+
 			log.WithFields(log.Fields{
 				"component": "search",
 			}).Debugf("Found '%s' for %q, queuing notification", item.ID, job.savedSearch.Search.Keywords)
 
 			s.notifier <- database.Notification{
-				User:   job.user,
-				Item:   item,
-				Search: job.savedSearch.Search.Keywords,
+				User:       job.user,
+				Item:       item,
+				Search:     job.savedSearch.Search.Keywords,
+				BelowUsual: market.BelowMedian(item.Price),
+				Warnings:   warnings,
+				FirstSeen:  firstSeen,
 			}
 		}
 
-		time.Sleep(time.Duration(10000+rand.Intn(5000)) * time.Millisecond)
+		s.adapt(job, items, newItems, ranAt)
 	}
 }
 
+// waitUnblocked pauses the caller while Wallapop is blocking us, rather than failing every request in the meantime
+func (s *Searcher) waitUnblocked() {
+	blocked, until := s.wp.Blocked()
+	if !blocked {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"component": "search",
+	}).Warnf("Wallapop is blocking us, pausing until %s", until.Format(time.RFC3339))
+	time.Sleep(time.Until(until))
+}
+
 func (s *Searcher) BacklogStats() (int, int) {
 	return len(s.backlog), cap(s.backlog)
 }
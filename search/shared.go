@@ -0,0 +1,214 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/wallapop"
+)
+
+// Locations are rounded to this many degrees, about a kilometer, so neighbours share their queries
+const locationQuantum = 0.01
+
+// Radiuses are rounded to this many meters, so searches with similar radiuses share their queries
+const radiusQuantum = 5000
+
+// Suffix of the key of results fetched for searches not covered by the others
+const uncoveredSuffix = "|uncovered"
+
+// Results older than this are never reused, no matter how seldom the searches sharing them run
+const maxSharedAge = 30 * time.Minute
+
+// sharedQueries coalesces equivalent searches from different users into a single Wallapop request.
+// Results are kept for a while, and every subscriber applies its own local filters to them.
+type sharedQueries struct {
+	wp *wallapop.Client
+
+	mtx     sync.Mutex
+	queries map[string]*sharedQuery
+}
+
+type sharedQuery struct {
+	done    chan struct{} // Closed once the request has finished
+	fetched time.Time
+	items   []wallapop.Item
+	err     error
+	partial bool // Paging may have stopped early at items known to the search that made the request
+}
+
+// Incremental results are reused by another search only if it knows one of this many oldest items, as results come
+// newest first and it has then seen everything older
+const partialOverlap = 10
+
+func newSharedQueries(wp *wallapop.Client) *sharedQueries {
+	return &sharedQueries{
+		wp:      wp,
+		queries: map[string]*sharedQuery{},
+	}
+}
+
+// Search returns the results for args, reusing those of an equivalent search made less than maxAge ago or waiting
+// for one in flight. Searches asking for fresh results always make their own request.
+func (sq *sharedQueries) Search(args wallapop.SearchArgs, maxAge time.Duration) ([]wallapop.Item, error) {
+	// Prices are up to each user, so they are filtered locally rather than upstream
+	local := args
+	local.Match = func(item *wallapop.Item) bool {
+		if (args.MaxPrice != 0 && item.Price > float64(args.MaxPrice)) || item.Price < float64(args.MinPrice) {
+			return false
+		}
+
+		return args.Match == nil || args.Match(item)
+	}
+
+	upstream := args
+	upstream.Keywords = strings.Join(strings.Fields(strings.ToLower(args.Keywords)), " ")
+	upstream.MaxPrice = 0
+	upstream.MinPrice = 0
+	upstream.RadiusM = quantizeRadius(args.RadiusM)
+	upstream.Latitude = quantize(args.Latitude)
+	upstream.Longitude = quantize(args.Longitude)
+	upstream.Match = nil
+	upstream.NoZero = false
+	upstream.Known = nil
+	key := sharedKey(upstream)
+
+	// Local filters are not applied upstream, so items they discard must count as known for paging to stop
+	if args.Known != nil {
+		upstream.Known = func(item *wallapop.Item) bool {
+			// Known is asked first, so the search learns about known items even if they are filtered out
+			return args.Known(item) || !local.Passes(item)
+		}
+	}
+
+	if maxAge > maxSharedAge {
+		maxAge = maxSharedAge
+	}
+
+	// Results that do not cover a search, because the one that made the request knew more, are fetched again under
+	// their own key so that they do not replace those others are waiting for
+	for _, k := range []string{key, key + uncoveredSuffix} {
+		q, owner := sq.join(k, upstream, maxAge, args.Fresh)
+		if owner {
+			return sq.fetch(k, q, upstream, local)
+		}
+
+		<-q.done
+		if q.err == nil && q.covers(args.Known) {
+			log.WithFields(log.Fields{
+				"component": "search",
+			}).Debugf("Reusing results for %q fetched %v ago", args.Keywords, time.Since(q.fetched).Round(time.Second))
+			return local.Filter(q.items), nil
+		}
+	}
+
+	// None of the shared results cover this search, so it makes a request of its own
+	return sq.fetch("", newSharedQuery(upstream), upstream, local)
+}
+
+// join returns the query under key if it is in flight or recent enough, or otherwise a new one it stores under key
+// for the caller to fetch. Both happen under the same lock, so equivalent searches never make concurrent requests.
+func (sq *sharedQueries) join(key string, upstream wallapop.SearchArgs, maxAge time.Duration, fresh bool) (*sharedQuery, bool) {
+	sq.mtx.Lock()
+	defer sq.mtx.Unlock()
+
+	sq.prune()
+	q, found := sq.queries[key]
+	if found && !fresh && (!q.finished() || time.Since(q.fetched) < maxAge) {
+		return q, false
+	}
+
+	q = newSharedQuery(upstream)
+	sq.queries[key] = q
+	return q, true
+}
+
+// fetch makes the request for a query stored under key, or not stored at all if key is empty
+func (sq *sharedQueries) fetch(key string, q *sharedQuery, upstream, local wallapop.SearchArgs) ([]wallapop.Item, error) {
+	q.items, q.err = sq.wp.Search(upstream)
+	q.fetched = time.Now()
+	close(q.done)
+
+	if q.err != nil {
+		// Errors are not worth sharing beyond those already waiting, next search should try again
+		sq.mtx.Lock()
+		if key != "" && sq.queries[key] == q {
+			delete(sq.queries, key)
+		}
+		sq.mtx.Unlock()
+
+		return nil, q.err
+	}
+
+	return local.Filter(q.items), nil
+}
+
+func newSharedQuery(upstream wallapop.SearchArgs) *sharedQuery {
+	return &sharedQuery{done: make(chan struct{}), partial: upstream.Known != nil}
+}
+
+// covers returns whether the results of the query include every item new to a search that knows the given items
+func (q *sharedQuery) covers(known func(item *wallapop.Item) bool) bool {
+	if !q.partial || len(q.items) == 0 {
+		return true
+	}
+
+	start := len(q.items) - partialOverlap
+	if start < 0 {
+		start = 0
+	}
+
+	for i := start; i < len(q.items); i++ {
+		if known != nil && known(&q.items[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prune drops results too old to be reused by any search. It must be called with the lock held.
+func (sq *sharedQueries) prune() {
+	for key, q := range sq.queries {
+		if q.finished() && time.Since(q.fetched) > maxSharedAge {
+			delete(sq.queries, key)
+		}
+	}
+}
+
+func (q *sharedQuery) finished() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sharedKey identifies equivalent upstream searches, which are made with normalized arguments
+func sharedKey(upstream wallapop.SearchArgs) string {
+	return fmt.Sprintf("%s|%d|%.2f|%.2f|%s|%s|%t|%t|%t|%d|%d",
+		upstream.Keywords, upstream.RadiusM, upstream.Latitude, upstream.Longitude, upstream.OrderBy, upstream.Language,
+		upstream.Urgent, upstream.Shipping, upstream.Exchange, upstream.Pages, upstream.Limit)
+}
+
+// quantizeRadius rounds a radius to the nearest radiusQuantum, keeping 0 as the default radius
+func quantizeRadius(meters int) int {
+	if meters == 0 {
+		return 0
+	}
+
+	quantized := int(math.Round(float64(meters)/radiusQuantum)) * radiusQuantum
+	if quantized == 0 {
+		quantized = radiusQuantum
+	}
+
+	return quantized
+}
+
+func quantize(degrees float64) float64 {
+	return math.Round(degrees/locationQuantum) * locationQuantum
+}
@@ -0,0 +1,60 @@
+package search
+
+import (
+	"testing"
+
+	"roob.re/wallabot/wallapop"
+)
+
+func TestSharedQuery_covers(t *testing.T) {
+	items := make([]wallapop.Item, 30)
+	for i := range items {
+		items[i].ID = string(rune('a' + i))
+	}
+
+	knows := func(ids ...string) func(item *wallapop.Item) bool {
+		return func(item *wallapop.Item) bool {
+			for _, id := range ids {
+				if item.ID == id {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    sharedQuery
+		known    func(item *wallapop.Item) bool
+		expected bool
+	}{
+		{name: "complete", query: sharedQuery{items: items}, known: nil, expected: true},
+		{name: "partial, not incremental", query: sharedQuery{items: items, partial: true}, known: nil, expected: false},
+		{name: "partial, knows oldest", query: sharedQuery{items: items, partial: true}, known: knows(items[29].ID), expected: true},
+		{name: "partial, knows newest", query: sharedQuery{items: items, partial: true}, known: knows(items[0].ID), expected: false},
+		{name: "partial, empty", query: sharedQuery{partial: true}, known: knows(), expected: true},
+	} {
+		if actual := tc.query.covers(tc.known); actual != tc.expected {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestSharedKey(t *testing.T) {
+	key := func(args wallapop.SearchArgs) string {
+		args.Keywords = "rtx 3080"
+		args.RadiusM = quantizeRadius(args.RadiusM)
+		args.Latitude = quantize(args.Latitude)
+		args.Longitude = quantize(args.Longitude)
+		return sharedKey(args)
+	}
+
+	base := key(wallapop.SearchArgs{RadiusM: 10000, Latitude: 41.3812, Longitude: 2.1734})
+	if other := key(wallapop.SearchArgs{RadiusM: 11000, Latitude: 41.3788, Longitude: 2.1729}); other != base {
+		t.Fatalf("expected nearby searches to share %q, got %q", base, other)
+	}
+	if other := key(wallapop.SearchArgs{RadiusM: 30000, Latitude: 41.3812, Longitude: 2.1734}); other == base {
+		t.Fatalf("expected different radiuses not to share %q", base)
+	}
+}
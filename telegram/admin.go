@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tucnak/telebot.v2"
+	"roob.re/wallabot/database"
+)
+
+const blockedCheckInterval = 30 * time.Second
+
+// Admins are told the Wallapop API may have changed at most this often, as every failing search would tell them again
+const changedAlertInterval = time.Hour
+
+// watchBlocked tells admins when Wallapop starts and stops blocking our requests
+func (wb *Wallabot) watchBlocked() {
+	wasBlocked := false
+
+	for {
+		time.Sleep(blockedCheckInterval)
+
+		blocked, until := wb.wp.Blocked()
+		if blocked == wasBlocked {
+			continue
+		}
+		wasBlocked = blocked
+
+		msg := "✅ Wallapop is answering again, searches have resumed"
+		if blocked {
+			msg = fmt.Sprintf("⛔ Wallapop is blocking us, searches are paused until %s", until.Format("15:04 MST"))
+		}
+
+		wb.notifyAdmins(msg)
+	}
+}
+
+// alertChanged tells admins that Wallapop may have changed its API, unless they were already told recently
+func (wb *Wallabot) alertChanged(err error) {
+	wb.alertMtx.Lock()
+	if time.Since(wb.changedAlert) < changedAlertInterval {
+		wb.alertMtx.Unlock()
+		return
+	}
+	wb.changedAlert = time.Now()
+	wb.alertMtx.Unlock()
+
+	wb.notifyAdmins(fmt.Sprintf("⚠️ Wallapop API may have changed, searches are failing: %v", err))
+}
+
+// notifyAdmins sends a message to every admin that has talked to the bot
+func (wb *Wallabot) notifyAdmins(msg string) {
+	if len(wb.c.Admins) == 0 {
+		return
+	}
+
+	err := wb.db.UserEach(func(u *database.User) error {
+		if !wb.userIsAdmin(u.Name) {
+			return nil
+		}
+
+		_, err := wb.bot.Send(telebot.ChatID(u.ChatID), msg)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error notifying admin '%s': %v", u.Name, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Error looking for admins: %v", err)
+	}
+}
+
+func (wb *Wallabot) userIsAdmin(username string) bool {
+	for _, u := range wb.c.Admins {
+		if strings.EqualFold(u, username) {
+			return true
+		}
+	}
+
+	return false
+}
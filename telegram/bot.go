@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -13,6 +14,9 @@ import (
 	"roob.re/wallabot/wallapop"
 )
 
+// Telegram rejects messages longer than 4096 characters, leave some room for the header
+const maxBatchLength = 3800
+
 type Wallabot struct {
 	Notify chan database.Notification
 
@@ -22,6 +26,9 @@ type Wallabot struct {
 
 	c        WallabotConfig
 	commands []commandEntry
+
+	alertMtx     sync.Mutex
+	changedAlert time.Time // Last time admins were told the Wallapop API may have changed
 }
 
 type WallabotConfig struct {
@@ -29,6 +36,13 @@ type WallabotConfig struct {
 	Timeout     time.Duration
 	QueueLength int
 	VIPUsers    []string
+	Admins      []string // Usernames told about problems with the bot, like Wallapop blocking it
+}
+
+// blockSellerButton is attached to notifications, and carries the ID of the seller to block
+var blockSellerButton = telebot.InlineButton{
+	Unique: "blockseller",
+	Text:   "🚫 Block seller",
 }
 
 type commandEntry struct {
@@ -116,6 +130,41 @@ func NewWallabot(token string, db *database.Database, wp *wallapop.Client, c Wal
 			description: "Show preferred location, manually",
 			handler:     wb.withUser(wb.HandleLocationText),
 		},
+		{
+			command:     "/exclude",
+			description: "Manage words that discard items from all my searches",
+			handler:     wb.withUser(wb.HandleExclude),
+		},
+		{
+			command:     "/blockseller",
+			description: "Discard all items from a seller",
+			handler:     wb.withUser(wb.HandleBlockSeller),
+		},
+		{
+			command:     "/unblockseller",
+			description: "Stop discarding items from a seller",
+			handler:     wb.withUser(wb.HandleUnblockSeller),
+		},
+		{
+			command:     "/history",
+			description: "Show how the price of an item has changed",
+			handler:     wb.withUser(wb.HandleHistory),
+		},
+		{
+			command:     "/digest",
+			description: "Get a periodic summary for a search instead of one message per item",
+			handler:     wb.withUser(wb.HandleDigest),
+		},
+		{
+			command:     "/gone",
+			description: "Get told when an item I notified you about is sold or reserved",
+			handler:     wb.withUser(wb.HandleGone),
+		},
+		{
+			command:     "/quiet",
+			description: "Set hours during which notifications are held back",
+			handler:     wb.withUser(wb.HandleQuiet),
+		},
 		{
 			command:     "/me",
 			description: "Show info about the current user",
@@ -138,6 +187,7 @@ func (wb *Wallabot) withHandlers() *Wallabot {
 		})
 	}
 
+	wb.bot.Handle(&blockSellerButton, wb.HandleBlockSellerButton)
 	wb.bot.Handle(telebot.OnLocation, wb.withUser(wb.HandleLocation))
 	wb.bot.Handle(telebot.OnText, wb.HandleHelp)
 
@@ -148,16 +198,38 @@ func (wb *Wallabot) withHandlers() *Wallabot {
 
 func (wb *Wallabot) Start() error {
 	go wb.processNotifications()
+	go wb.processQuietQueue()
+	go wb.processDigests()
+	go wb.watchBlocked()
 	wb.bot.Start()
 	return nil
 }
 
 func (wb *Wallabot) processNotifications() {
 	for nt := range wb.Notify {
+		if nt.Gone != "" {
+			wb.processGone(nt)
+			continue
+		}
+
 		// Check if we already sent a notification for this search and item for a lower or same price
 		lowerPriceNotified := false
 		shouldNotify := true
-		err := wb.db.User(nt.User.ID, func(u *database.User) error {
+		digest := false
+		quiet := false
+		// Items above budget are not notified, but their price is watched to detect when they drop into budget
+		overBudget := false
+		var previousPrice float64
+		// Searches of the user matching the item, which are listed in the message and marked as notified together
+		var matching []string
+		watchedPrice, watched, err := wb.db.Watched(nt.User.ID, nt.Search, nt.Item.ID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error checking watched price of '%s' for '%s': %v", nt.Item.ID, nt.User.Name, err)
+		}
+
+		err = wb.db.UserWithSent(nt.User.ID, func(u *database.User) error {
 			search := u.Searches.Get(nt.Search)
 			if search == nil {
 				return fmt.Errorf("search %q not found", nt.Search)
@@ -167,9 +239,32 @@ func (wb *Wallabot) processNotifications() {
 				shouldNotify = false
 			}
 
+			digest = search.Delivery.Interval() != 0
+			quiet = u.Quiet.Active(time.Now())
+
+			if int(nt.Item.Price) > search.Search.MaxPrice {
+				overBudget = true
+				return nil
+			}
+
 			notifiedPrice, notified := search.SentItems[nt.Item.ID]
-			if notified && notifiedPrice <= nt.Item.Price {
+			if !notified {
+				// Another search with overlapping results may have notified about the item already
+				notifiedPrice, notified = u.Notified[nt.Item.ID]
+			}
+
+			matching = u.MatchingSearches(nt.Item, nt.Search)
+
+			switch {
+			case notified && notifiedPrice <= nt.Item.Price:
+				lowerPriceNotified = true
+			case notified && !search.Search.MinDrop.Reached(notifiedPrice, nt.Item.Price):
+				// Price dropped, but not enough. Keep the old price so smaller drops can add up.
 				lowerPriceNotified = true
+			case notified:
+				previousPrice = notifiedPrice
+			case watched && watchedPrice > nt.Item.Price:
+				previousPrice = watchedPrice
 			}
 			return nil
 		})
@@ -180,6 +275,16 @@ func (wb *Wallabot) processNotifications() {
 			continue
 		}
 
+		if overBudget {
+			_, err = wb.db.Watch(nt.User.ID, nt.Search, nt.Item.ID, nt.Item.Price, time.Now())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "bot",
+				}).Errorf("internal error watching '%s' for '%s': %v", nt.Item.ID, nt.Search, err)
+			}
+			continue
+		}
+
 		if lowerPriceNotified {
 			log.WithFields(log.Fields{
 				"component": "bot",
@@ -187,14 +292,96 @@ func (wb *Wallabot) processNotifications() {
 			continue
 		}
 
+		if shouldNotify && digest {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Debugf("Queuing '%s' for the next digest of %q for '%s'", nt.Item.ID, nt.Search, nt.User.Name)
+
+			err = wb.db.Enqueue(database.QueueDigest, database.Pending{
+				UserID:        nt.User.ID,
+				Search:        nt.Search,
+				Item:          *nt.Item,
+				PreviousPrice: previousPrice,
+				BelowUsual:    nt.BelowUsual,
+				Warnings:      nt.Warnings,
+				FirstSeen:     nt.FirstSeen,
+			})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "bot",
+				}).Errorf("Error queuing '%s' for digest of %q: %v", nt.Item.ID, nt.Search, err)
+			}
+			continue
+		}
+
+		if shouldNotify && quiet {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Debugf("Holding '%s' for '%s' until quiet hours end", nt.Item.ID, nt.User.Name)
+
+			err = wb.db.Enqueue(database.QueueQuiet, database.Pending{
+				UserID:        nt.User.ID,
+				Search:        nt.Search,
+				Item:          *nt.Item,
+				PreviousPrice: previousPrice,
+				BelowUsual:    nt.BelowUsual,
+				Warnings:      nt.Warnings,
+				FirstSeen:     nt.FirstSeen,
+			})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "bot",
+				}).Errorf("Error holding '%s' for '%s' during quiet hours: %v", nt.Item.ID, nt.User.Name, err)
+			}
+			continue
+		}
+
 		if shouldNotify {
 			log.WithFields(log.Fields{
 				"component": "bot",
 			}).Printf("Notifying '%s' about '%s'", nt.User.Name, nt.Item.ID)
 
-			_, err = wb.bot.Send(telebot.ChatID(nt.User.ChatID), nt.Item.Markdown(), &telebot.SendOptions{
+			msg := nt.Item.Markdown()
+			if len(nt.Warnings) > 0 {
+				msg = nt.Item.MarkdownWarnings(nt.Warnings) + "\n" + msg
+			}
+
+			if previousPrice != 0 {
+				msg += "\n" + nt.Item.MarkdownPriceDrop(previousPrice)
+			}
+
+			if !nt.FirstSeen.IsZero() {
+				msg += "\n" + nt.Item.MarkdownRepost(nt.FirstSeen)
+			}
+
+			if nt.BelowUsual > 0 {
+				msg += "\n" + nt.Item.MarkdownDeal(nt.BelowUsual)
+			}
+
+			if len(matching) > 1 {
+				msg += "\n" + searchesLine(matching)
+			}
+
+			lowest, hasHistory, err := wb.db.LowestPrice(nt.Item.ID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "bot",
+				}).Warnf("Could not get price history of '%s': %v", nt.Item.ID, err)
+			}
+			if hasHistory {
+				msg += "\n" + nt.Item.MarkdownLowest(lowest)
+			}
+
+			options := &telebot.SendOptions{
 				ParseMode: telebot.ModeMarkdownV2,
-			})
+			}
+			if nt.Item.Seller.ID != "" {
+				options.ReplyMarkup = &telebot.ReplyMarkup{
+					InlineKeyboard: [][]telebot.InlineButton{{*blockSellerButton.With(nt.Item.Seller.ID)}},
+				}
+			}
+
+			_, err = wb.bot.Send(telebot.ChatID(nt.User.ChatID), msg, options)
 			if err != nil {
 				log.WithFields(log.Fields{
 					"component": "bot",
@@ -203,19 +390,84 @@ func (wb *Wallabot) processNotifications() {
 			}
 		}
 
-		err = wb.db.UserUpdate(nt.User.ID, func(u *database.User) error {
-			search := u.Searches.Get(nt.Search)
-			if search == nil {
-				return fmt.Errorf("search '%s' not found", nt.Search)
+		err = wb.db.MarkSent(nt.User.ID, nt.Item, matching...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("internal error updating notification for '%s': %v", nt.Search, err)
+		}
+	}
+}
+
+// sendBatch sends a header followed by a list of markdown lines, splitting it in several messages if needed
+func (wb *Wallabot) sendBatch(chatID int64, header string, lines []string) error {
+	msg := &strings.Builder{}
+	msg.WriteString(header)
+	msg.WriteString("\n")
+
+	for _, line := range lines {
+		if msg.Len()+len(line) > maxBatchLength {
+			_, err := wb.bot.Send(telebot.ChatID(chatID), msg.String(), &telebot.SendOptions{
+				ParseMode:             telebot.ModeMarkdownV2,
+				DisableWebPagePreview: true,
+			})
+			if err != nil {
+				return err
 			}
+			msg.Reset()
+		}
 
-			search.SentItems[nt.Item.ID] = nt.Item.Price
-			return nil
-		})
+		msg.WriteString("\n")
+		msg.WriteString(line)
+	}
+
+	_, err := wb.bot.Send(telebot.ChatID(chatID), msg.String(), &telebot.SendOptions{
+		ParseMode:             telebot.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	})
+	return err
+}
+
+// pendingLine formats a held back notification as a line for a batch message
+func pendingLine(p database.Pending) string {
+	line := p.Item.MarkdownLine()
+	if len(p.Warnings) > 0 {
+		line = "⚠️ " + line
+	}
+
+	if p.PreviousPrice > p.Item.Price {
+		line += " " + p.Item.MarkdownPriceDrop(p.PreviousPrice)
+	}
+
+	if p.BelowUsual > 0 {
+		line += " " + p.Item.MarkdownDeal(p.BelowUsual)
+	}
+
+	if !p.FirstSeen.IsZero() {
+		line += " " + p.Item.MarkdownRepost(p.FirstSeen)
+	}
+
+	return line
+}
+
+// searchesLine lists the searches that matched an item
+func searchesLine(searches []string) string {
+	quoted := make([]string, 0, len(searches))
+	for _, s := range searches {
+		quoted = append(quoted, "`"+codeEscaper.Replace(s)+"`")
+	}
+
+	return "🔎 Matches " + strings.Join(quoted, ", ")
+}
+
+// markSent records delivered notifications so they are not sent again
+func (wb *Wallabot) markSent(u *database.User, delivered []database.Pending) {
+	for _, p := range delivered {
+		err := wb.db.MarkSent(u.ID, &p.Item, p.Search)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"component": "bot",
-			}).Errorf("internal error updating notification for '%s': %v", nt.Search, err)
+			}).Errorf("internal error updating notification of '%s' for '%s': %v", p.Item.ID, u.Name, err)
 		}
 	}
 }
@@ -251,6 +503,9 @@ func (wb *Wallabot) userIsVIP(username string) bool {
 	return false
 }
 
+// Inside markdown code spans only these need escaping
+var codeEscaper = strings.NewReplacer("`", "\\`", `\`, `\\`)
+
 func sendLog(m *telebot.Message, err error) *telebot.Message {
 	if err != nil {
 		log.WithFields(log.Fields{
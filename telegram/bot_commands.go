@@ -1,14 +1,19 @@
 package telegram
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/tucnak/telebot.v2"
 	"roob.re/wallabot/database"
 	searchcmd "roob.re/wallabot/telegram/search"
+	"roob.re/wallabot/wallapop"
 )
 
 func (wb *Wallabot) HandleSearch(m *telebot.Message) {
@@ -16,15 +21,13 @@ func (wb *Wallabot) HandleSearch(m *telebot.Message) {
 
 	search, err := searchcmd.New(m.Payload)
 	if err != nil {
-		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("Error %v", err),
-		))
+		sendLog(wb.bot.Reply(m, searchErrorMessage(search, err)))
 		return
 	}
 
 	if search.Keywords == "" || search.MaxPrice == 0 {
 		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("`Usage: %s <price=100> [radius=100] [strict=false] [nozero=false] search string...`", "/search"),
+			fmt.Sprintf("`Usage: %s <price=100> [radius=100] [strict=false] [desc=false] [nozero=false] search string...`\n%s", "/search", queryUsage),
 		))
 		return
 	}
@@ -56,25 +59,43 @@ func (wb *Wallabot) HandleSearch(m *telebot.Message) {
 	args.Latitude = lat
 	args.Longitude = long
 
-	results, err := wb.wp.Search(args)
+	// Fetch pages only until there are enough results to show
+	exclusions := searchcmd.NewExclusions(user.Excluded)
+	var seen, results []wallapop.Item
+	it := wb.wp.SearchIter(args)
+	for len(results) < maxResults && it.Next() {
+		item := it.Item()
+		seen = append(seen, *item)
+		if !exclusions.Match(item.Title+"\n"+item.Description) && !user.BlocksSeller(item) {
+			results = append(results, *item)
+		}
+	}
+
+	err = wb.db.RecordPrices(seen, time.Now())
 	if err != nil {
-		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("Error processing your search: %v", err),
-		))
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Error recording prices for %q: %v", search.Keywords, err)
+	}
+
+	if it.Err() != nil && len(results) == 0 {
+		sendLog(wb.bot.Reply(m, wallapopErrorMessage(it.Err())))
+		if wallapopChanged(it.Err()) {
+			wb.alertChanged(it.Err())
+		}
 
 		return
 	}
 
 	if len(results) == 0 {
 		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("Could not find any results for '%s'", search),
+			fmt.Sprintf("Could not find any results for '%s'", search.Keywords),
 		))
 
 		return
 	}
 
-	if len(results) > maxResults {
-		results = results[:maxResults]
+	if len(results) == maxResults {
 		sendLog(wb.bot.Reply(m,
 			fmt.Sprintf("__Limiting search results to %d__\n", maxResults),
 		))
@@ -86,23 +107,62 @@ func (wb *Wallabot) HandleSearch(m *telebot.Message) {
 	}
 }
 
+// queryUsage explains the syntax of search strings
+const queryUsage = "`Search strings: rtx (3080 OR 3090) -laptop \"founders edition\"`\n" +
+	"`Alternatives must share a word, which is what Wallapop is asked for. OR and -exclusions are applied by the bot.`"
+
+// wallapopErrorMessage explains to the user why Wallapop could not be searched, and whether trying again later may help
+func wallapopErrorMessage(err error) string {
+	var rateLimited *wallapop.ErrRateLimited
+	var forbidden *wallapop.ErrForbidden
+	var unavailable *wallapop.ErrUpstreamUnavailable
+
+	switch {
+	case errors.As(err, &rateLimited), errors.As(err, &forbidden):
+		return "Wallapop is limiting how often I can search right now, please try again in a few minutes"
+	case errors.As(err, &unavailable):
+		return "Wallapop is not answering right now, please try again later"
+	case wallapopChanged(err):
+		return "Wallapop did not understand me, the bot may need an update"
+	default:
+		return fmt.Sprintf("Error processing your search: %v", err)
+	}
+}
+
+// wallapopChanged returns whether an error suggests Wallapop changed its API, so retrying will not help
+func wallapopChanged(err error) bool {
+	var signature *wallapop.ErrSignatureRejected
+	var decode *wallapop.ErrDecode
+
+	return errors.As(err, &signature) || errors.As(err, &decode)
+}
+
+// searchErrorMessage formats an error parsing a search, pointing at the offending token for query errors
+func searchErrorMessage(search searchcmd.Search, err error) string {
+	var perr *searchcmd.ParseError
+	if errors.As(err, &perr) {
+		return fmt.Sprintf("Error %v\n```\n%s\n```", err, perr.Caret(search.Keywords))
+	}
+
+	return fmt.Sprintf("Error %v", err)
+}
+
 func (wb *Wallabot) HandleNewSearch(m *telebot.Message) {
 	search, err := searchcmd.New(m.Payload)
 	if err != nil {
-		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("Error %v", err),
-		))
+		sendLog(wb.bot.Reply(m, searchErrorMessage(search, err)))
 		return
 	}
 
 	if search.Keywords == "" || search.MaxPrice == 0 {
 		sendLog(wb.bot.Reply(m,
-			fmt.Sprintf("`Usage: %s <price=100> [radius=100] [strict=false] [nozero=false] search string...`", "/search"),
+			fmt.Sprintf("`Usage: %s <price=100> [radius=100] [strict=false] [fuzzy=0] [desc=false] [nozero=false] [drop=10%%] [match=regex] [exclude=regex] [sellers=id,id] [percentile=25] [scam=off|mark|hide] [reposts=off|mark|hide] [every=30m] [fresh=true] search string...`\n%s", "/new", queryUsage),
 		))
 		return
 	}
 
-	err = wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+	// Items notified by a search replaced by this one are forgotten
+	err = wb.db.UserUpdateWithSent(m.Sender.ID, func(u *database.User) error {
 		if (!wb.userIsVIP(m.Sender.Username) && len(u.Searches) >= 5) ||
 			len(u.Searches) >= 15 {
 			return fmt.Errorf("you have reached the maximum number of searches")
@@ -129,7 +189,7 @@ func (wb *Wallabot) HandleNewSearch(m *telebot.Message) {
 
 func (wb *Wallabot) HandleSavedSearches(m *telebot.Message) {
 	var searches database.SavedSearches
-	err := wb.db.User(m.Sender.ID, func(u *database.User) error {
+	err := wb.db.UserWithSent(m.Sender.ID, func(u *database.User) error {
 		searches = u.Searches
 		return nil
 	})
@@ -166,7 +226,8 @@ func (wb *Wallabot) HandleDeleteSearch(m *telebot.Message) {
 	}
 
 	var found bool
-	err := wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+	// Items notified by the search are forgotten along with it
+	err := wb.db.UserUpdateWithSent(m.Sender.ID, func(u *database.User) error {
 		found = u.Searches.Delete(m.Payload)
 		return nil
 	})
@@ -184,11 +245,388 @@ func (wb *Wallabot) HandleDeleteSearch(m *telebot.Message) {
 		return
 	}
 
+	if err := wb.db.MarketDelete(m.Sender.ID, m.Payload); err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Warnf("Could not delete price distribution of %q: %v", m.Payload, err)
+	}
+
 	sendLog(wb.bot.Reply(m,
 		fmt.Sprintf("Search `%s` has been deleted", keywords),
 	))
 }
 
+func (wb *Wallabot) HandleDigest(m *telebot.Message) {
+	const usage = "`Usage: /digest <instant|hourly|daily> <search>`"
+
+	args := strings.SplitN(strings.TrimSpace(m.Payload), " ", 2)
+	if len(args) != 2 {
+		sendLog(wb.bot.Reply(m, usage))
+		return
+	}
+
+	mode, err := database.ParseDeliveryMode(args[0])
+	if err != nil {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("Error %v\n%s", err, usage),
+		))
+		return
+	}
+
+	keywords := strings.TrimSpace(args[1])
+	var found, wasDigest bool
+	var user *database.User
+	err = wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+		user = u
+		ss := u.Searches.Get(keywords)
+		if ss == nil {
+			return nil
+		}
+
+		found = true
+		wasDigest = ss.Delivery.Interval() != 0
+		if !wasDigest {
+			// Start counting from now, so the first digest is not sent right away
+			ss.LastDigest = time.Now()
+		}
+		ss.Delivery = mode
+		return nil
+	})
+	if err != nil {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error updating saved search: %v", err),
+		))
+		return
+	}
+
+	if !found {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("You do not have any saved search for `%s`", keywords),
+		))
+		return
+	}
+
+	if mode.Interval() == 0 {
+		if wasDigest {
+			// Send whatever was waiting for the next digest, so it is not left behind
+			wb.flushDigest(user, keywords, time.Now())
+		}
+
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("I'll notify you about `%s` as soon as I find something", keywords),
+		))
+		return
+	}
+
+	sendLog(wb.bot.Reply(m,
+		fmt.Sprintf("I'll send you a %s digest for `%s`", mode, keywords),
+	))
+}
+
+func (wb *Wallabot) HandleHistory(m *telebot.Message) {
+	const maxChanges = 20
+
+	ref := strings.TrimSpace(m.Payload)
+	if ref == "" {
+		sendLog(wb.bot.Reply(m,
+			"`Usage: /history <item id or url>`",
+		))
+		return
+	}
+
+	itemID := ref
+	if u, err := url.Parse(ref); err == nil && strings.Contains(u.Path, "/item/") {
+		slug := path.Base(u.Path)
+		itemID, err = wb.db.ItemIDFromSlug(slug)
+		if err != nil {
+			sendLog(wb.bot.Reply(m,
+				fmt.Sprintf("error looking up item: %v", err),
+			))
+			return
+		}
+	}
+
+	var points []database.PricePoint
+	var err error
+	if itemID != "" {
+		points, err = wb.db.PriceHistory(itemID)
+		if err != nil {
+			sendLog(wb.bot.Reply(m,
+				fmt.Sprintf("error getting price history: %v", err),
+			))
+			return
+		}
+	}
+
+	if len(points) == 0 {
+		sendLog(wb.bot.Reply(m,
+			"I haven't seen that item in any search yet",
+		))
+		return
+	}
+
+	loc := time.UTC
+	_ = wb.db.User(m.Sender.ID, func(u *database.User) error {
+		loc = u.Quiet.Location()
+		return nil
+	})
+
+	// Only show observations where the price changed
+	changes := []database.PricePoint{points[0]}
+	lowest, highest := points[0].Price, points[0].Price
+	for _, p := range points[1:] {
+		if p.Price != changes[len(changes)-1].Price {
+			changes = append(changes, p)
+		}
+		if p.Price < lowest {
+			lowest = p.Price
+		}
+		if p.Price > highest {
+			highest = p.Price
+		}
+	}
+
+	msg := &strings.Builder{}
+	fmt.Fprintf(msg, "📈 Price history for `%s`\n\n", itemID)
+	if len(changes) > maxChanges {
+		fmt.Fprintf(msg, "_%d older changes not shown_\n", len(changes)-maxChanges)
+		changes = changes[len(changes)-maxChanges:]
+	}
+	for _, p := range changes {
+		fmt.Fprintf(msg, "`%s` %d€\n", p.Time.In(loc).Format("2006-01-02 15:04"), int(p.Price))
+	}
+	lastSeen := points[len(points)-1].Time
+	if _, seen, found, err := wb.db.LastPrice(itemID); err == nil && found && seen.After(lastSeen) {
+		lastSeen = seen
+	}
+	fmt.Fprintf(msg, "\n⬇️ %d€ ⬆️ %d€, last seen %s",
+		int(lowest), int(highest), lastSeen.In(loc).Format("2006-01-02 15:04"),
+	)
+
+	sendLog(wb.bot.Reply(m, msg.String()))
+}
+
+func (wb *Wallabot) HandleExclude(m *telebot.Message) {
+	const maxExcluded = 50
+	const usage = "`Usage: /exclude <add|remove> <word or phrase>`\n`       /exclude list`"
+
+	args := strings.SplitN(strings.TrimSpace(m.Payload), " ", 2)
+	action := strings.ToLower(args[0])
+	var entry string
+	if len(args) == 2 {
+		entry = strings.ToLower(strings.TrimSpace(args[1]))
+	}
+
+	if (action == "add" || action == "remove") && entry == "" {
+		sendLog(wb.bot.Reply(m, usage))
+		return
+	}
+
+	var excluded []string
+	var changed bool
+	err := wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+		switch action {
+		case "add":
+			for _, e := range u.Excluded {
+				if e == entry {
+					return nil
+				}
+			}
+
+			if len(u.Excluded) >= maxExcluded {
+				return fmt.Errorf("you can't exclude more than %d words", maxExcluded)
+			}
+
+			u.Excluded = append(u.Excluded, entry)
+			changed = true
+
+		case "remove":
+			for i, e := range u.Excluded {
+				if e == entry {
+					u.Excluded = append(u.Excluded[:i], u.Excluded[i+1:]...)
+					changed = true
+					break
+				}
+			}
+		}
+
+		excluded = u.Excluded
+		return nil
+	})
+	if err != nil {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error updating excluded words: %v", err),
+		))
+		return
+	}
+
+	switch action {
+	case "add":
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("Items containing `%s` will be discarded from all your searches", entry),
+		))
+
+	case "remove":
+		if !changed {
+			sendLog(wb.bot.Reply(m,
+				fmt.Sprintf("You were not excluding `%s`", entry),
+			))
+			return
+		}
+
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("Items containing `%s` will not be discarded anymore", entry),
+		))
+
+	case "list", "":
+		if len(excluded) == 0 {
+			sendLog(wb.bot.Reply(m,
+				"You are not excluding any word. You can add one with `/exclude add <word>`.",
+			))
+			return
+		}
+
+		msg := "🚫 Items containing any of these are discarded from all your searches:\n"
+		for _, e := range excluded {
+			msg += fmt.Sprintf("- `%s`\n", e)
+		}
+		sendLog(wb.bot.Reply(m, strings.TrimSpace(msg)))
+
+	default:
+		sendLog(wb.bot.Reply(m, usage))
+	}
+}
+
+func (wb *Wallabot) HandleBlockSeller(m *telebot.Message) {
+	seller := sellerFromRef(m.Payload)
+	if seller == "" {
+		var blocked []string
+		err := wb.db.User(m.Sender.ID, func(u *database.User) error {
+			blocked = u.Blocked
+			return nil
+		})
+		if err != nil {
+			sendLog(wb.bot.Reply(m,
+				fmt.Sprintf("error getting blocked sellers: %v", err),
+			))
+			return
+		}
+
+		msg := "`Usage: /blockseller <profile url or seller id>`"
+		if len(blocked) > 0 {
+			msg += "\n\nYou are blocking these sellers:\n"
+			for _, b := range blocked {
+				msg += fmt.Sprintf("- `%s`\n", b)
+			}
+		}
+		sendLog(wb.bot.Reply(m, strings.TrimSpace(msg)))
+		return
+	}
+
+	err := wb.blockSeller(m.Sender.ID, seller)
+	if err != nil {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error blocking seller: %v", err),
+		))
+		return
+	}
+
+	sendLog(wb.bot.Reply(m,
+		fmt.Sprintf("Items from `%s` will be discarded from all your searches", seller),
+	))
+}
+
+func (wb *Wallabot) HandleUnblockSeller(m *telebot.Message) {
+	seller := sellerFromRef(m.Payload)
+	if seller == "" {
+		sendLog(wb.bot.Reply(m,
+			"`Usage: /unblockseller <profile url or seller id>`",
+		))
+		return
+	}
+
+	var found bool
+	err := wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+		for i, b := range u.Blocked {
+			if b == seller {
+				u.Blocked = append(u.Blocked[:i], u.Blocked[i+1:]...)
+				found = true
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error unblocking seller: %v", err),
+		))
+		return
+	}
+
+	if !found {
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("You were not blocking `%s`", seller),
+		))
+		return
+	}
+
+	sendLog(wb.bot.Reply(m,
+		fmt.Sprintf("Items from `%s` will not be discarded anymore", seller),
+	))
+}
+
+func (wb *Wallabot) HandleBlockSellerButton(c *telebot.Callback) {
+	response := &telebot.CallbackResponse{Text: "Seller blocked, you won't see their items again"}
+
+	err := wb.blockSeller(c.Sender.ID, c.Data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Blocking seller '%s' for user %d: %v", c.Data, c.Sender.ID, err)
+
+		response.Text = fmt.Sprintf("Error blocking seller: %v", err)
+	}
+
+	err = wb.bot.Respond(c, response)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("error responding to callback: %v", err)
+	}
+}
+
+func (wb *Wallabot) blockSeller(userID int, seller string) error {
+	const maxBlocked = 200
+
+	return wb.db.UserUpdate(userID, func(u *database.User) error {
+		for _, b := range u.Blocked {
+			if b == seller {
+				return nil
+			}
+		}
+
+		if len(u.Blocked) >= maxBlocked {
+			return fmt.Errorf("you can't block more than %d sellers", maxBlocked)
+		}
+
+		u.Blocked = append(u.Blocked, seller)
+		return nil
+	})
+}
+
+// sellerFromRef returns the seller identifier from either a profile URL or a raw ID
+func sellerFromRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if u, err := url.Parse(ref); err == nil && u.Host != "" {
+		if seller := path.Base(u.Path); seller != "." && seller != "/" {
+			return seller
+		}
+		return ""
+	}
+
+	return ref
+}
+
 func (wb *Wallabot) HandleLocation(m *telebot.Message) {
 	if m.Location == nil {
 		sendLog(wb.bot.Reply(m,
@@ -293,6 +731,94 @@ func (wb *Wallabot) HandleRadius(m *telebot.Message) {
 	))
 }
 
+func (wb *Wallabot) HandleQuiet(m *telebot.Message) {
+	const usage = "`Usage: /quiet <HH:MM-HH:MM> [timezone]`\n`Example: /quiet 23:00-08:00 Europe/Madrid`\n`Disable: /quiet off`"
+
+	args := strings.Fields(m.Payload)
+	if len(args) == 0 || len(args) > 2 {
+		sendLog(wb.bot.Reply(m, usage))
+		return
+	}
+
+	var quiet database.QuietHours
+	if strings.ToLower(args[0]) != "off" {
+		timezone := ""
+		if len(args) == 2 {
+			timezone = args[1]
+		}
+
+		var err error
+		quiet, err = database.ParseQuietHours(args[0], timezone)
+		if err != nil {
+			sendLog(wb.bot.Reply(m,
+				fmt.Sprintf("Error %v\n%s", err, usage),
+			))
+			return
+		}
+	}
+
+	err := wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+		u.Quiet = quiet
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Saving quiet hours ('%s') for user %d: %v", m.Payload, m.Sender.ID, err)
+
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error saving your quiet hours: %v", err),
+		))
+		return
+	}
+
+	if !quiet.Enabled() {
+		sendLog(wb.bot.Reply(m, "Quiet hours disabled, I'll notify you as soon as I find something"))
+		return
+	}
+
+	sendLog(wb.bot.Reply(m,
+		fmt.Sprintf("I'll hold notifications between `%s` and send them to you when it's over", quiet),
+	))
+}
+
+func (wb *Wallabot) HandleGone(m *telebot.Message) {
+	var enable bool
+	switch strings.ToLower(strings.TrimSpace(m.Payload)) {
+	case "on":
+		enable = true
+	case "off":
+		enable = false
+	default:
+		sendLog(wb.bot.Reply(m,
+			"`Usage: /gone <on|off>`",
+		))
+		return
+	}
+
+	err := wb.db.UserUpdate(m.Sender.ID, func(u *database.User) error {
+		u.NotifyGone = enable
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Saving gone notifications for user %d: %v", m.Sender.ID, err)
+
+		sendLog(wb.bot.Reply(m,
+			fmt.Sprintf("error saving your preference: %v", err),
+		))
+		return
+	}
+
+	if enable {
+		sendLog(wb.bot.Reply(m, "I'll tell you when an item I notified you about is sold, reserved or deleted"))
+		return
+	}
+
+	sendLog(wb.bot.Reply(m, "I won't tell you anymore when notified items are sold, reserved or deleted"))
+}
+
 func (wb *Wallabot) HandleMe(m *telebot.Message) {
 	var user *database.User
 	err := wb.db.User(m.Sender.ID, func(u *database.User) error {
@@ -314,10 +840,12 @@ func (wb *Wallabot) HandleMe(m *telebot.Message) {
 	sendLog(wb.bot.Reply(m,
 		fmt.Sprintf("👤 %s\n"+
 			"📍 %.8f, %.8f (+%dKm)\n"+
+			"🌙 `%s`\n"+
 			vipMessage+
 			"You can send me your location fo configure it, and use /radius to set your desired search radius",
 			user.Name,
 			user.Lat, user.Long, user.RadiusKm,
+			user.Quiet,
 		),
 	))
 }
@@ -332,6 +860,7 @@ func (wb *Wallabot) HandleHelp(m *telebot.Message) {
 		"Oopsie woopsie, I did not get that command :(\n"+
 			"I currently support the following ones:\n\n"+
 			supportedStr+
+			"\n"+queryUsage+"\n"+
 			"\nAdditionally you can send me a location directly to easily update your preferred location",
 	))
 }
@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+)
+
+const digestInterval = time.Minute
+
+// processDigests periodically sends a summary of the items queued for searches in digest mode
+func (wb *Wallabot) processDigests() {
+	for {
+		time.Sleep(digestInterval)
+
+		type due struct {
+			user   *database.User
+			search string
+		}
+
+		now := time.Now()
+		var dueDigests []due
+		err := wb.db.UserEach(func(u *database.User) error {
+			if u.Quiet.Active(now) {
+				return nil
+			}
+
+			for keywords, ss := range u.Searches {
+				if ss.DigestDue(now) {
+					dueDigests = append(dueDigests, due{user: u, search: keywords})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error gathering due digests: %v", err)
+			continue
+		}
+
+		for _, d := range dueDigests {
+			wb.flushDigest(d.user, d.search, now)
+		}
+	}
+}
+
+// flushDigest sends all queued items for a search in a single message, sorted by price
+func (wb *Wallabot) flushDigest(u *database.User, search string, now time.Time) {
+	pending, err := wb.db.PendingList(database.QueueDigest, u.ID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Error reading digest queue for '%s': %v", u.Name, err)
+		return
+	}
+
+	if len(pending) > 0 {
+		err = wb.db.LoadSent(u)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error reading notified items of '%s': %v", u.Name, err)
+			return
+		}
+	}
+
+	// Items queued before the search was muted are dropped, as if they had been found afterwards
+	muted := false
+	if ss := u.Searches.Get(search); ss == nil || ss.Muted {
+		muted = true
+	}
+
+	var items []database.Pending
+	var stale []database.Pending
+	for _, p := range pending {
+		if p.Search != search {
+			continue
+		}
+
+		// Another search may have notified about the item since it was queued
+		if muted || u.AlreadyNotified(p.Item.ID, p.Item.Price) {
+			stale = append(stale, p)
+			continue
+		}
+
+		items = append(items, p)
+	}
+
+	if len(stale) > 0 {
+		err = wb.db.PendingDelete(database.QueueDigest, stale)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error clearing digest queue for '%s': %v", u.Name, err)
+		}
+	}
+
+	if len(items) > 0 {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Item.Price < items[j].Item.Price
+		})
+
+		lines := make([]string, 0, len(items))
+		for _, p := range items {
+			lines = append(lines, pendingLine(p))
+		}
+
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Printf("Sending digest of %d items for %q to '%s'", len(items), search, u.Name)
+
+		header := fmt.Sprintf("📰 *%d new items for* `%s`", len(items), codeEscaper.Replace(search))
+		err = wb.sendBatch(u.ChatID, header, lines)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error sending digest for %q to '%s' (chatID %d): %v", search, u.Name, u.ChatID, err)
+			return
+		}
+
+		wb.markSent(u, items)
+
+		err = wb.db.PendingDelete(database.QueueDigest, items)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error clearing digest queue for '%s': %v", u.Name, err)
+		}
+	}
+
+	err = wb.db.UserUpdate(u.ID, func(u *database.User) error {
+		if ss := u.Searches.Get(search); ss != nil {
+			ss.LastDigest = now
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("internal error updating digest time for %q: %v", search, err)
+	}
+}
@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/tucnak/telebot.v2"
+	"roob.re/wallabot/database"
+	"roob.re/wallabot/wallapop"
+)
+
+// processGone removes an item that is no longer available from the search, and tells the user if they want to.
+// Users in quiet hours are told once those end.
+func (wb *Wallabot) processGone(nt database.Notification) {
+	notifyGone := false
+	quiet := false
+	err := wb.db.UserUpdateWithSent(nt.User.ID, func(u *database.User) error {
+		// Items matched by several searches are forgotten by all of them at once, so the user is told only once
+		notifyGone = u.Forget(nt.Item.ID) && u.NotifyGone
+		quiet = u.Quiet.Active(time.Now())
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("internal error forgetting '%s' for '%s': %v", nt.Item.ID, nt.Search, err)
+		return
+	}
+
+	if !notifyGone {
+		return
+	}
+
+	if quiet {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Debugf("Holding '%s' being %s for '%s' until quiet hours end", nt.Item.ID, nt.Gone, nt.User.Name)
+
+		// Not tied to a search, as it is told once for all of them
+		err = wb.db.Enqueue(database.QueueQuiet, database.Pending{
+			UserID: nt.User.ID,
+			Item:   *nt.Item,
+			Gone:   nt.Gone,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error holding '%s' for '%s' during quiet hours: %v", nt.Item.ID, nt.User.Name, err)
+		}
+		return
+	}
+
+	wb.sendGone(nt.User, nt.Item, nt.Gone)
+}
+
+// sendGone tells a user that an item they were notified about is no longer available
+func (wb *Wallabot) sendGone(u *database.User, item *wallapop.Item, gone database.ItemStatus) {
+	log.WithFields(log.Fields{
+		"component": "bot",
+	}).Printf("Notifying '%s' that '%s' is %s", u.Name, item.ID, gone)
+
+	_, err := wb.bot.Send(telebot.ChatID(u.ChatID), item.MarkdownGone(string(gone)), &telebot.SendOptions{
+		ParseMode:             telebot.ModeMarkdownV2,
+		DisableWebPagePreview: true,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Printf("Error notifying '%s' (chatID %d) about '%s' being %s: %v", u.Name, u.ChatID, item.ID, gone, err)
+	}
+}
@@ -0,0 +1,150 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"roob.re/wallabot/database"
+)
+
+const quietQueueInterval = time.Minute
+
+// processQuietQueue periodically delivers notifications held back during quiet hours for users whose window has ended
+func (wb *Wallabot) processQuietQueue() {
+	for {
+		time.Sleep(quietQueueInterval)
+
+		var users []*database.User
+		err := wb.db.UserEach(func(u *database.User) error {
+			if !u.Quiet.Active(time.Now()) {
+				users = append(users, u)
+			}
+			return nil
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error gathering users for quiet queue: %v", err)
+			continue
+		}
+
+		for _, u := range users {
+			pending, err := wb.db.PendingList(database.QueueQuiet, u.ID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"component": "bot",
+				}).Errorf("Error reading quiet queue for '%s': %v", u.Name, err)
+				continue
+			}
+
+			if len(pending) == 0 {
+				continue
+			}
+
+			wb.flushQuietQueue(u, pending)
+		}
+	}
+}
+
+// flushQuietQueue sends held back notifications as a single batch, skipping items whose price went above the search
+// budget in the meantime. Prices come from the latest search results, as looking every item up would burst requests
+// when many quiet windows end at once.
+func (wb *Wallabot) flushQuietQueue(u *database.User, pending []database.Pending) {
+	err := wb.db.LoadSent(u)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Error reading notified items of '%s': %v", u.Name, err)
+		return
+	}
+
+	var delivered []database.Pending
+	var gone []database.Pending
+	// Searches that matched each delivered item, as an item found by several searches is listed only once
+	searches := map[string][]string{}
+
+	for _, p := range pending {
+		if p.Gone != "" {
+			gone = append(gone, p)
+			continue
+		}
+
+		search := u.Searches.Get(p.Search)
+		if search == nil || search.Muted {
+			continue
+		}
+		search.LegacyFill()
+
+		if _, listed := searches[p.Item.ID]; listed {
+			searches[p.Item.ID] = append(searches[p.Item.ID], p.Search)
+			delivered = append(delivered, p)
+			continue
+		}
+
+		if u.AlreadyNotified(p.Item.ID, p.Item.Price) {
+			continue
+		}
+
+		price, seen, found, err := wb.db.LastPrice(p.Item.ID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Warnf("Could not get last price of '%s' before delivering it: %v", p.Item.ID, err)
+		}
+		if found && seen.After(p.Queued) {
+			p.Item.Price = price
+		}
+
+		if int(p.Item.Price) > search.Search.MaxPrice {
+			continue
+		}
+
+		delivered = append(delivered, p)
+		searches[p.Item.ID] = []string{p.Search}
+	}
+
+	var lines []string
+	for _, p := range delivered {
+		matched, first := searches[p.Item.ID]
+		if !first {
+			continue
+		}
+		delete(searches, p.Item.ID)
+
+		quoted := make([]string, 0, len(matched))
+		for _, s := range matched {
+			quoted = append(quoted, "`"+codeEscaper.Replace(s)+"`")
+		}
+		lines = append(lines, pendingLine(p)+" "+strings.Join(quoted, " "))
+	}
+
+	if len(lines) > 0 {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Printf("Delivering %d items held during quiet hours to '%s'", len(lines), u.Name)
+
+		header := fmt.Sprintf("🌙 *%d items found during quiet hours*", len(lines))
+		err := wb.sendBatch(u.ChatID, header, lines)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "bot",
+			}).Errorf("Error delivering quiet queue to '%s' (chatID %d): %v", u.Name, u.ChatID, err)
+			return
+		}
+
+		wb.markSent(u, delivered)
+	}
+
+	for _, p := range gone {
+		wb.sendGone(u, &p.Item, p.Gone)
+	}
+
+	err = wb.db.PendingDelete(database.QueueQuiet, pending)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "bot",
+		}).Errorf("Error clearing quiet queue for '%s': %v", u.Name, err)
+	}
+}
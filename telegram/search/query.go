@@ -0,0 +1,395 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Query is a parsed boolean expression over keywords.
+// Adjacent terms must all match, `OR` (or `|`) matches either side, `-term` excludes items containing term,
+// "quoted phrases" must appear as consecutive words and parentheses group expressions.
+// Terms match whole words, ignoring case and diacritics.
+type Query struct {
+	root node
+
+	// Fuzzy is the number of typos (edits) allowed for a word to match a term
+	Fuzzy int
+}
+
+// ParseError is returned when a query is not valid, and points to the offending token
+type ParseError struct {
+	Pos   int // Byte offset of the offending token in the query
+	Token string
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at end of query", e.Msg)
+	}
+
+	return fmt.Sprintf("%s at position %d: %q", e.Msg, e.Pos+1, e.Token)
+}
+
+// Caret returns the query with a line below it pointing at the offending token
+func (e *ParseError) Caret(query string) string {
+	return query + "\n" + strings.Repeat(" ", len([]rune(query[:e.Pos]))) + "^"
+}
+
+// ParseQuery parses a boolean keyword query
+func ParseQuery(raw string) (*Query, error) {
+	tokens, err := tokenize(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &ParseError{Pos: t.pos, Token: t.text, Msg: "unexpected token"}
+	}
+
+	return &Query{root: root}, nil
+}
+
+// Match returns whether text satisfies the query
+func (q *Query) Match(text string) bool {
+	return q.root.match(&document{words: words(text), fuzzy: q.Fuzzy})
+}
+
+// Keywords returns the words every item matching the query must contain, to be sent to the Wallapop API, which does
+// not understand grouping, alternatives nor exclusions. Those must be applied locally, see Plain.
+func (q *Query) Keywords() string {
+	return strings.Join(q.root.required(), " ")
+}
+
+// Plain returns whether the query is only a list of terms, so Keywords is all there is to it
+func (q *Query) Plain() bool {
+	return q.root.plain()
+}
+
+// document is a text split in normalized words, ready to be matched against a query
+type document struct {
+	words []string
+	fuzzy int
+}
+
+type node interface {
+	match(doc *document) bool
+	required() []string // Words any matching text must contain
+	plain() bool        // Whether there are no alternatives nor exclusions
+}
+
+type termNode struct {
+	text  string   // Lowercase, as written by the user
+	words []string // Normalized
+}
+
+func (n termNode) match(doc *document) bool {
+	for start := 0; start+len(n.words) <= len(doc.words); start++ {
+		found := true
+		for i, word := range n.words {
+			if !tokenMatch(doc.words[start+i], word, doc.fuzzy) {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n termNode) required() []string {
+	return strings.Fields(n.text)
+}
+
+func (n termNode) plain() bool {
+	return true
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) match(doc *document) bool {
+	return !n.child.match(doc)
+}
+
+func (n notNode) required() []string {
+	return nil
+}
+
+func (n notNode) plain() bool {
+	return false
+}
+
+type andNode struct {
+	children []node
+}
+
+func (n andNode) match(doc *document) bool {
+	for _, c := range n.children {
+		if !c.match(doc) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n andNode) required() []string {
+	var words []string
+	added := map[string]bool{}
+	for _, c := range n.children {
+		for _, word := range c.required() {
+			if !added[word] {
+				added[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+
+	return words
+}
+
+func (n andNode) plain() bool {
+	for _, c := range n.children {
+		if !c.plain() {
+			return false
+		}
+	}
+
+	return true
+}
+
+type orNode struct {
+	children []node
+}
+
+func (n orNode) match(doc *document) bool {
+	for _, c := range n.children {
+		if c.match(doc) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Only the words shared by all alternatives are required
+func (n orNode) required() []string {
+	shared := n.children[0].required()
+	for _, c := range n.children[1:] {
+		other := map[string]bool{}
+		for _, word := range c.required() {
+			other[word] = true
+		}
+
+		var kept []string
+		for _, word := range shared {
+			if other[word] {
+				kept = append(kept, word)
+			}
+		}
+		shared = kept
+	}
+
+	return shared
+}
+
+func (n orNode) plain() bool {
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokPhrase
+	tokMinus
+	tokOr
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenize(raw string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(raw); {
+		switch c := raw[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+
+		case c == '|':
+			tokens = append(tokens, token{kind: tokOr, text: "|", pos: i})
+			i++
+
+		case c == '-':
+			if i+1 >= len(raw) || strings.ContainsRune(" \t\n)|", rune(raw[i+1])) {
+				return nil, &ParseError{Pos: i, Token: "-", Msg: "expected a term to exclude after"}
+			}
+			tokens = append(tokens, token{kind: tokMinus, text: "-", pos: i})
+			i++
+
+		case c == '"':
+			end := strings.IndexByte(raw[i+1:], '"')
+			if end == -1 {
+				return nil, &ParseError{Pos: i, Token: raw[i:], Msg: "unterminated quote"}
+			}
+
+			phrase := strings.Join(strings.Fields(raw[i+1:i+1+end]), " ")
+			if phrase == "" {
+				return nil, &ParseError{Pos: i, Token: raw[i : i+2+end], Msg: "empty phrase"}
+			}
+
+			tokens = append(tokens, token{kind: tokPhrase, text: phrase, pos: i})
+			i += end + 2
+
+		default:
+			end := strings.IndexAny(raw[i:], " \t\n()|\"")
+			if end == -1 {
+				end = len(raw) - i
+			}
+
+			word := raw[i : i+end]
+			kind := tokWord
+			if word == "OR" {
+				kind = tokOr
+			}
+
+			tokens = append(tokens, token{kind: kind, text: word, pos: i})
+			i += end
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(raw)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) pop() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+
+	return t
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *parser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []node{first}
+	for p.peek().kind == tokOr {
+		p.pop()
+
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+
+	return orNode{children: children}, nil
+}
+
+// parseAnd parses: unary+
+func (p *parser) parseAnd() (node, error) {
+	var children []node
+	for {
+		switch p.peek().kind {
+		case tokEOF, tokOr, tokRParen:
+			if len(children) == 0 {
+				t := p.peek()
+				return nil, &ParseError{Pos: t.pos, Token: t.text, Msg: "expected a term"}
+			}
+
+			if len(children) == 1 {
+				return children[0], nil
+			}
+
+			return andNode{children: children}, nil
+		}
+
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+}
+
+// parseUnary parses: "-" unary | word | phrase | "(" or ")"
+func (p *parser) parseUnary() (node, error) {
+	t := p.pop()
+	switch t.kind {
+	case tokMinus:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{child: child}, nil
+
+	case tokWord, tokPhrase:
+		termWords := words(t.text)
+		if len(termWords) == 0 {
+			return nil, &ParseError{Pos: t.pos, Token: t.text, Msg: "term has no letters or digits"}
+		}
+
+		return termNode{text: strings.ToLower(t.text), words: termWords}, nil
+
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.pop(); closing.kind != tokRParen {
+			return nil, &ParseError{Pos: t.pos, Token: t.text, Msg: "unclosed parenthesis"}
+		}
+
+		return inner, nil
+
+	default:
+		return nil, &ParseError{Pos: t.pos, Token: t.text, Msg: "unexpected token"}
+	}
+}
@@ -0,0 +1,139 @@
+package search_test
+
+import (
+	"errors"
+	"testing"
+
+	"roob.re/wallabot/telegram/search"
+)
+
+func TestQuery_Match(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		text     string
+		expected bool
+	}{
+		{query: "rtx 3080", text: "Nvidia RTX 3080 Founders", expected: true},
+		{query: "rtx 3080", text: "Nvidia RTX 3070", expected: false},
+		{query: `"rtx 3080"`, text: "RTX 3080 Ti", expected: true},
+		{query: `"rtx 3080"`, text: "3080 RTX", expected: false},
+		{query: "rtx -laptop", text: "Portátil laptop RTX", expected: false},
+		{query: "rtx -laptop", text: "RTX 3070", expected: true},
+		{query: "3080 OR 3090", text: "RTX 3090", expected: true},
+		{query: "3080 | 3090", text: "RTX 3070", expected: false},
+		{query: "rtx (3080 OR 3090) -ti", text: "RTX 3080", expected: true},
+		{query: "rtx (3080 OR 3090) -ti", text: "RTX 3080 ti", expected: false},
+		{query: `-("for parts" OR broken) gpu`, text: "GPU for parts", expected: false},
+		{query: `-("for parts" OR broken) gpu`, text: "GPU like new", expected: true},
+		{query: "camara", text: "Cámara réflex Canon", expected: true},
+		{query: "cámara -réflex", text: "Camara REFLEX", expected: false},
+		{query: "rtx", text: "Portátil ASUS rtx-2060", expected: true},
+		{query: "rtx 3080", text: "RTX 3080Ti", expected: false},
+		{query: "ram", text: "Cámara de fotos", expected: false},
+	} {
+		q, err := search.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("Parsing %q: %v", tc.query, err)
+		}
+
+		if actual := q.Match(tc.text); actual != tc.expected {
+			t.Fatalf("Query %q on %q: expected %v, got %v", tc.query, tc.text, tc.expected, actual)
+		}
+	}
+}
+
+func TestQuery_Keywords(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "rtx 3080", expected: "rtx 3080"},
+		{query: `"rtx 3080" -laptop`, expected: "rtx 3080"},
+		{query: "rtx (3080 OR 3090)", expected: "rtx"},
+		{query: "rtx -(laptop OR portatil)", expected: "rtx"},
+		{query: "(nvidia rtx 3080) OR (rtx 3090 nvidia)", expected: "nvidia rtx"},
+		{query: "3080 OR 3090", expected: ""},
+	} {
+		q, err := search.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("Parsing %q: %v", tc.query, err)
+		}
+
+		if actual := q.Keywords(); actual != tc.expected {
+			t.Fatalf("Query %q: expected keywords %q, got %q", tc.query, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseQuery_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		pos   int
+	}{
+		{query: "rtx (3080", pos: 4},
+		{query: "rtx 3080)", pos: 8},
+		{query: `rtx "3080`, pos: 4},
+		{query: "rtx - 3080", pos: 4},
+		{query: "OR rtx", pos: 0},
+		{query: "rtx OR", pos: 6},
+		{query: "rtx () 3080", pos: 5},
+	} {
+		_, err := search.ParseQuery(tc.query)
+
+		var perr *search.ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("Query %q: expected parse error, got %v", tc.query, err)
+		}
+
+		if perr.Pos != tc.pos {
+			t.Fatalf("Query %q: expected error at %d, got %d (%v)", tc.query, tc.pos, perr.Pos, perr)
+		}
+	}
+}
+
+func TestQuery_Match_Fuzzy(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		fuzzy    int
+		text     string
+		expected bool
+	}{
+		{query: "nvidia", fuzzy: 0, text: "Tarjeta NVIDEA", expected: false},
+		{query: "nvidia", fuzzy: 1, text: "Tarjeta NVIDEA", expected: true},
+		{query: "nvidia", fuzzy: 1, text: "Tarjeta NVIDEAA", expected: false},
+		{query: "nvidia", fuzzy: 2, text: "Tarjeta NVIDEAA", expected: true},
+		{query: "rtx 3080", fuzzy: 1, text: "RTX 3070", expected: false},
+		{query: "ram", fuzzy: 1, text: "Cam", expected: false},
+		{query: `"tarjeta grafica"`, fuzzy: 1, text: "targeta gráfica", expected: true},
+	} {
+		q, err := search.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("Parsing %q: %v", tc.query, err)
+		}
+		q.Fuzzy = tc.fuzzy
+
+		if actual := q.Match(tc.text); actual != tc.expected {
+			t.Fatalf("Query %q (fuzzy %d) on %q: expected %v, got %v", tc.query, tc.fuzzy, tc.text, tc.expected, actual)
+		}
+	}
+}
+
+func TestExclusions_Match(t *testing.T) {
+	exclusions := search.NewExclusions([]string{"roto", "averiado", "para piezas", "busco"})
+	for _, tc := range []struct {
+		text     string
+		expected bool
+	}{
+		{text: "RTX 3080 nueva", expected: false},
+		{text: "RTX 3080 ROTA", expected: false},
+		{text: "RTX 3080\nVentilador roto", expected: true},
+		{text: "Portátil AVERIADO", expected: true},
+		{text: "Placa base para piezas", expected: true},
+		{text: "Piezas para placa base", expected: false},
+		{text: "Búsco RTX 3080", expected: true},
+	} {
+		if actual := exclusions.Match(tc.text); actual != tc.expected {
+			t.Fatalf("Exclusions on %q: expected %v, got %v", tc.text, tc.expected, actual)
+		}
+	}
+}
@@ -1,24 +1,168 @@
 package search
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"roob.re/wallabot/wallapop"
 )
 
 type Search struct {
-	Keywords string
-	MaxPrice int
-	MinPrice int
-	Strict   bool
-	RadiusKm int
-	NoZero   bool
+	Keywords   string
+	MaxPrice   int
+	MinPrice   int
+	Strict     bool
+	RadiusKm   int
+	NoZero     bool
+	MinDrop    Drop
+	Desc       bool     // Match strict queries against the description of items too, not just their title
+	Match      string   // Regular expression that the title or description of items must match
+	Exclude    string   // Regular expression that the title or description of items must not match
+	Fuzzy      int      // Number of typos allowed when matching words in strict mode
+	Sellers    string   // Comma-separated IDs or profile slugs of the only sellers whose items are wanted
+	Percentile int      // Discard items more expensive than this percentile of the prices seen by the search
+	Scam       string   // What to do with items that look like scams, one of ScamOff, ScamMark or ScamHide
+	Reposts    string   // What to do with items re-listed by their seller, one of RepostsOff, RepostsMark or RepostsHide
+	Every      Interval // How often the search is run, or 0 for the default
+	Fresh      bool     // Never use cached results for the search
+}
+
+const (
+	ScamOff  = ""
+	ScamMark = "mark"
+	ScamHide = "hide"
+)
+
+// Telling reposts apart takes downloading pictures, so searches only do it if asked to
+const (
+	RepostsOff  = ""
+	RepostsMark = "mark"
+	RepostsHide = "hide"
+)
+
+// CheckReposts returns whether reposts are looked for at all. Searches saved when it was the default spell off out.
+func (s Search) CheckReposts() bool {
+	return s.Reposts == RepostsMark || s.Reposts == RepostsHide
+}
+
+const maxFuzzy = 2
+
+// Drop is the minimum price drop required to notify again about an item that was already notified
+type Drop struct {
+	Amount  int
+	Percent bool // If true, Amount is a percentage of the previous price rather than an absolute value
+}
+
+func parseDrop(raw string) (Drop, error) {
+	d := Drop{}
+	if strings.HasSuffix(raw, "%") {
+		d.Percent = true
+		raw = strings.TrimSuffix(raw, "%")
+	}
+
+	var err error
+	d.Amount, err = strconv.Atoi(raw)
+	if err != nil {
+		return d, err
+	}
+
+	if d.Amount < 0 || (d.Percent && d.Amount > 100) {
+		return d, fmt.Errorf("%q is out of range", raw)
+	}
+
+	return d, nil
+}
+
+// Reached returns whether a price going from before to after is a big enough drop
+func (d Drop) Reached(before, after float64) bool {
+	if after >= before {
+		return false
+	}
+
+	if d.Percent {
+		return (before-after)/before*100 >= float64(d.Amount)
+	}
+
+	return before-after >= float64(d.Amount)
+}
+
+func (d Drop) String() string {
+	if d.Percent {
+		return fmt.Sprintf("%d%%", d.Amount)
+	}
+
+	return fmt.Sprintf("%d€", d.Amount)
+}
+
+// Interval is how often a search is run
+type Interval time.Duration
+
+const (
+	minInterval = 5 * time.Minute
+	maxInterval = 7 * 24 * time.Hour
+)
+
+func parseInterval(raw string) (Interval, error) {
+	var d time.Duration
+	switch raw {
+	case "hourly":
+		d = time.Hour
+	case "daily":
+		d = 24 * time.Hour
+	default:
+		var err error
+		d, err = time.ParseDuration(raw)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if d < minInterval || d > maxInterval {
+		return 0, fmt.Errorf("%q is out of range, must be between %v and %v", raw, minInterval, maxInterval)
+	}
+
+	return Interval(d), nil
+}
+
+func (i Interval) String() string {
+	s := time.Duration(i).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
 }
 
 const keyValueSeparator = "="
 
+// compileFilter compiles a regular expression filter, which always matches case-insensitively
+func compileFilter(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("regular expression must not be empty")
+	}
+
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		var reErr *syntax.Error
+		if errors.As(err, &reErr) {
+			return nil, fmt.Errorf("invalid regular expression %q: %s", expr, reErr.Code)
+		}
+
+		return nil, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+	}
+
+	return re, nil
+}
+
 func New(raw string) (Search, error) {
 	s := Search{}
 
@@ -29,14 +173,46 @@ func New(raw string) (Search, error) {
 			continue
 		}
 
-		parts := strings.Split(field, keyValueSeparator)
-		if len(parts) != 2 {
-			keywords = append(keywords, parts...)
+		parts := strings.SplitN(field, keyValueSeparator, 2)
+		key := strings.ToLower(parts[0])
+
+		// Regular expressions and seller IDs are case sensitive, and the former may contain the separator
+		switch key {
+		case "sellers":
+			var sellers []string
+			for _, seller := range strings.Split(parts[1], ",") {
+				if seller = strings.TrimSpace(seller); seller != "" {
+					sellers = append(sellers, seller)
+				}
+			}
+
+			if len(sellers) == 0 {
+				return s, fmt.Errorf("parsing sellers: list must not be empty")
+			}
+
+			s.Sellers = strings.Join(sellers, ",")
+			continue
+
+		case "match", "exclude":
+			_, err := compileFilter(parts[1])
+			if err != nil {
+				return s, fmt.Errorf("parsing %s: %w", key, err)
+			}
+
+			if key == "match" {
+				s.Match = parts[1]
+			} else {
+				s.Exclude = parts[1]
+			}
+			continue
+		}
+
+		if strings.Contains(parts[1], keyValueSeparator) {
+			keywords = append(keywords, strings.Split(field, keyValueSeparator)...)
 			continue
 		}
 
 		var err error
-		key := strings.ToLower(parts[0])
 		value := strings.ToLower(parts[1])
 		switch key {
 		case "max", "price":
@@ -63,6 +239,72 @@ func New(raw string) (Search, error) {
 				return s, fmt.Errorf("parsing nozero: %w", err)
 			}
 
+		case "fresh":
+			s.Fresh, err = strconv.ParseBool(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing fresh: %w", err)
+			}
+
+		case "desc", "description":
+			s.Desc, err = strconv.ParseBool(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing desc: %w", err)
+			}
+
+		case "fuzzy":
+			s.Fuzzy, err = strconv.Atoi(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing fuzzy: %w", err)
+			}
+
+			if s.Fuzzy < 0 || s.Fuzzy > maxFuzzy {
+				return s, fmt.Errorf("fuzzy must be between 0 and %d", maxFuzzy)
+			}
+
+		case "percentile":
+			s.Percentile, err = strconv.Atoi(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing percentile: %w", err)
+			}
+
+			if s.Percentile < 1 || s.Percentile > 99 {
+				return s, fmt.Errorf("percentile must be between 1 and 99")
+			}
+
+		case "scam":
+			switch value {
+			case "off", "false":
+				s.Scam = ScamOff
+			case ScamMark, ScamHide:
+				s.Scam = value
+			default:
+				return s, fmt.Errorf("scam must be one of off, %s or %s", ScamMark, ScamHide)
+			}
+
+		case "reposts":
+			switch value {
+			case "off", "false":
+				s.Reposts = RepostsOff
+			case "true":
+				s.Reposts = RepostsMark
+			case RepostsMark, RepostsHide:
+				s.Reposts = value
+			default:
+				return s, fmt.Errorf("reposts must be one of off, %s or %s", RepostsMark, RepostsHide)
+			}
+
+		case "every":
+			s.Every, err = parseInterval(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing every: %w", err)
+			}
+
+		case "drop":
+			s.MinDrop, err = parseDrop(value)
+			if err != nil {
+				return s, fmt.Errorf("parsing drop: %w", err)
+			}
+
 		case "radius":
 			s.RadiusKm, err = strconv.Atoi(value)
 			if err != nil {
@@ -76,16 +318,153 @@ func New(raw string) (Search, error) {
 
 	s.Keywords = strings.Join(keywords, " ")
 
+	if s.Keywords != "" {
+		query, err := ParseQuery(s.Keywords)
+		if err != nil {
+			return s, err
+		}
+
+		if query.Keywords() == "" {
+			return s, fmt.Errorf("every alternative of the query must share a word to search for, " +
+				"try one search for each alternative instead")
+		}
+	}
+
 	return s, nil
 }
 
+// Matches returns whether an item, found by some other search, would be a result of this one too.
+// Keywords are always matched locally, as if the search was strict, since there is no way to tell how Wallapop
+// would have ranked the item.
+func (s Search) Matches(item *wallapop.Item) bool {
+	return s.matcher()(item)
+}
+
+// Compiled by matcher, by search, so queries and filters are not parsed again for every item matched against them
+var (
+	matchersMtx sync.Mutex
+	matchers    = map[Search]func(item *wallapop.Item) bool{}
+)
+
+// Compiled matchers are all dropped once there are this many, which only happens if searches keep changing
+const maxMatchers = 1024
+
+// matcher returns the function Matches uses for this search, compiling it only the first time
+func (s Search) matcher() func(item *wallapop.Item) bool {
+	matchersMtx.Lock()
+	defer matchersMtx.Unlock()
+
+	if m, found := matchers[s]; found {
+		return m
+	}
+
+	var query *Query
+	if q, err := ParseQuery(s.Keywords); err == nil {
+		q.Fuzzy = s.Fuzzy
+		query = q
+	}
+	args := s.Args()
+
+	m := func(item *wallapop.Item) bool {
+		if s.MaxPrice != 0 && int(item.Price) > s.MaxPrice {
+			return false
+		}
+
+		if int(item.Price) < s.MinPrice || (s.NoZero && item.Price == 0) {
+			return false
+		}
+
+		if query != nil && !query.Match(item.Title+"\n"+item.Description) {
+			return false
+		}
+
+		return args.Match == nil || args.Match(item)
+	}
+
+	if len(matchers) >= maxMatchers {
+		matchers = map[Search]func(item *wallapop.Item) bool{}
+	}
+	matchers[s] = m
+
+	return m
+}
+
 func (s Search) Args() wallapop.SearchArgs {
-	return wallapop.SearchArgs{
+	args := wallapop.SearchArgs{
 		Keywords: s.Keywords,
 		MaxPrice: s.MaxPrice,
 		MinPrice: s.MinPrice,
 		RadiusM:  s.RadiusKm * 1000,
-		Strict:   s.Strict,
 		NoZero:   s.NoZero,
+		Fresh:    s.Fresh,
 	}
+
+	var filters []func(item *wallapop.Item) bool
+
+	// Searches saved before queries were introduced may not parse, keep using them as plain keywords
+	query, err := ParseQuery(s.Keywords)
+	if err == nil {
+		// Searches saved before alternatives had to share a word keep sending the whole query
+		if keywords := query.Keywords(); keywords != "" {
+			args.Keywords = keywords
+		}
+
+		query.Fuzzy = s.Fuzzy
+		switch {
+		case s.Strict:
+			filters = append(filters, func(item *wallapop.Item) bool {
+				text := item.Title
+				if s.Desc {
+					text += "\n" + item.Description
+				}
+
+				return query.Match(text)
+			})
+		case !query.Plain():
+			// Wallapop only got the shared words, so alternatives and exclusions are up to us, leniently like Matches
+			filters = append(filters, func(item *wallapop.Item) bool {
+				return query.Match(item.Title + "\n" + item.Description)
+			})
+		}
+	}
+
+	// Filters are validated when the search is created, so they should always compile here
+	if match, err := compileFilter(s.Match); err == nil {
+		filters = append(filters, func(item *wallapop.Item) bool {
+			return match.MatchString(item.Title) || match.MatchString(item.Description)
+		})
+	}
+
+	if exclude, err := compileFilter(s.Exclude); err == nil {
+		filters = append(filters, func(item *wallapop.Item) bool {
+			return !exclude.MatchString(item.Title) && !exclude.MatchString(item.Description)
+		})
+	}
+
+	if s.Sellers != "" {
+		sellers := strings.Split(s.Sellers, ",")
+		filters = append(filters, func(item *wallapop.Item) bool {
+			for _, seller := range sellers {
+				if seller == item.Seller.ID || (item.Seller.Slug != "" && seller == item.Seller.Slug) {
+					return true
+				}
+			}
+
+			return false
+		})
+	}
+
+	if len(filters) > 0 {
+		args.Match = func(item *wallapop.Item) bool {
+			for _, f := range filters {
+				if !f(item) {
+					return false
+				}
+			}
+
+			return true
+		}
+	}
+
+	return args
 }
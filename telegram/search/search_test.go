@@ -2,8 +2,10 @@ package search_test
 
 import (
 	"testing"
+	"time"
 
 	"roob.re/wallabot/telegram/search"
+	"roob.re/wallabot/wallapop"
 )
 
 func TestNew(t *testing.T) {
@@ -48,6 +50,64 @@ func TestNew(t *testing.T) {
 				NoZero:   true,
 			},
 		},
+		{
+			raw: "dropping search drop=20",
+			expected: search.Search{
+				Keywords: "dropping search",
+				MinDrop:  search.Drop{Amount: 20},
+			},
+		},
+		{
+			raw: "drop=15% dropping search",
+			expected: search.Search{
+				Keywords: "dropping search",
+				MinDrop:  search.Drop{Amount: 15, Percent: true},
+			},
+		},
+		{
+			raw: `strict=true desc=true "rtx 3080" -laptop`,
+			expected: search.Search{
+				Keywords: `"rtx 3080" -laptop`,
+				Strict:   true,
+				Desc:     true,
+			},
+		},
+		{
+			raw: `ram match=\b(16|32)\s?GB\b exclude=ddr3`,
+			expected: search.Search{
+				Keywords: "ram",
+				Match:    `\b(16|32)\s?GB\b`,
+				Exclude:  "ddr3",
+			},
+		},
+		{
+			raw: "monitor sellers=aBc123,xyz-42",
+			expected: search.Search{
+				Keywords: "monitor",
+				Sellers:  "aBc123,xyz-42",
+			},
+		},
+		{
+			raw: "gpu every=1h30m",
+			expected: search.Search{
+				Keywords: "gpu",
+				Every:    search.Interval(90 * time.Minute),
+			},
+		},
+		{
+			raw: "gpu reposts=hide",
+			expected: search.Search{
+				Keywords: "gpu",
+				Reposts:  search.RepostsHide,
+			},
+		},
+		{
+			raw: "gpu reposts=off",
+			expected: search.Search{
+				Keywords: "gpu",
+				Reposts:  search.RepostsOff,
+			},
+		},
 	} {
 		actual, err := search.New(tc.raw)
 		if err != nil {
@@ -59,3 +119,83 @@ func TestNew(t *testing.T) {
 		}
 	}
 }
+
+func TestNew_InvalidFilter(t *testing.T) {
+	for _, raw := range []string{
+		"ram match=(16|32",
+		"ram exclude=",
+		"ram exclude=[a-",
+		"gpu every=1m",
+		"gpu reposts=maybe",
+	} {
+		if _, err := search.New(raw); err == nil {
+			t.Fatalf("Search %q should have failed", raw)
+		}
+	}
+}
+
+func TestSearch_Args_Filters(t *testing.T) {
+	s, err := search.New(`ram match=\b(16|32)\s?gb\b exclude=ddr3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match := s.Args().Match
+	for _, tc := range []struct {
+		item     wallapop.Item
+		expected bool
+	}{
+		{item: wallapop.Item{Title: "RAM 16GB DDR4"}, expected: true},
+		{item: wallapop.Item{Title: "RAM kit", Description: "2x16 gb"}, expected: false},
+		{item: wallapop.Item{Title: "RAM kit", Description: "32 GB, 3200MHz"}, expected: true},
+		{item: wallapop.Item{Title: "RAM 16GB", Description: "DDR3 1600"}, expected: false},
+		{item: wallapop.Item{Title: "RAM 8GB DDR4"}, expected: false},
+	} {
+		if actual := match(&tc.item); actual != tc.expected {
+			t.Fatalf("Item %v: expected %v, got %v", tc.item, tc.expected, actual)
+		}
+	}
+}
+
+func TestSearch_Matches(t *testing.T) {
+	s, err := search.New("rtx 3080 max=600 exclude=roto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		item     wallapop.Item
+		expected bool
+	}{
+		{item: wallapop.Item{Title: "RTX 3080", Price: 550}, expected: true},
+		{item: wallapop.Item{Title: "Tarjeta gráfica", Description: "Nvidia RTX 3080", Price: 500}, expected: true},
+		{item: wallapop.Item{Title: "3080", Price: 550}, expected: false},
+		{item: wallapop.Item{Title: "RTX 3080", Price: 650}, expected: false},
+		{item: wallapop.Item{Title: "RTX 3080 ventilador roto", Price: 300}, expected: false},
+	} {
+		if actual := s.Matches(&tc.item); actual != tc.expected {
+			t.Fatalf("Item %v: expected %v, got %v", tc.item, tc.expected, actual)
+		}
+	}
+}
+
+func TestDrop_Reached(t *testing.T) {
+	for _, tc := range []struct {
+		drop     search.Drop
+		before   float64
+		after    float64
+		expected bool
+	}{
+		{drop: search.Drop{}, before: 100, after: 99, expected: true},
+		{drop: search.Drop{}, before: 100, after: 100, expected: false},
+		{drop: search.Drop{}, before: 100, after: 120, expected: false},
+		{drop: search.Drop{Amount: 20}, before: 100, after: 81, expected: false},
+		{drop: search.Drop{Amount: 20}, before: 100, after: 80, expected: true},
+		{drop: search.Drop{Amount: 10, Percent: true}, before: 250, after: 230, expected: false},
+		{drop: search.Drop{Amount: 10, Percent: true}, before: 250, after: 199, expected: true},
+	} {
+		if actual := tc.drop.Reached(tc.before, tc.after); actual != tc.expected {
+			t.Fatalf("Drop %v from %.0f to %.0f: expected %v, got %v", tc.drop, tc.before, tc.after, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fuzzy matching is not applied to words shorter than this, as almost any short word is one edit away from another
+const minFuzzyLength = 4
+
+// normalize lowercases s and strips diacritics from it, so "Cámara" becomes "camara"
+func normalize(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(t, s)
+	if err != nil {
+		normalized = s
+	}
+
+	return strings.ToLower(normalized)
+}
+
+// words splits a normalized version of s into words made of letters and digits
+func words(s string) []string {
+	return strings.FieldsFunc(normalize(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tokenMatch returns whether word matches target, allowing up to fuzzy edits for long enough words.
+// Words containing digits must always match exactly, as "3070" is one edit away from "3080".
+func tokenMatch(word, target string, fuzzy int) bool {
+	if word == target {
+		return true
+	}
+
+	if fuzzy == 0 || len([]rune(target)) < minFuzzyLength || strings.IndexFunc(target, unicode.IsDigit) != -1 {
+		return false
+	}
+
+	return editDistance(word, target) <= fuzzy
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(br)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
+
+// Exclusions matches texts containing any of a list of words or phrases, ignoring case and diacritics
+type Exclusions struct {
+	terms []termNode
+}
+
+func NewExclusions(list []string) Exclusions {
+	e := Exclusions{}
+	for _, entry := range list {
+		if entryWords := words(entry); len(entryWords) > 0 {
+			e.terms = append(e.terms, termNode{text: strings.ToLower(entry), words: entryWords})
+		}
+	}
+
+	return e
+}
+
+// Match returns whether text contains any of the excluded words or phrases
+func (e Exclusions) Match(text string) bool {
+	if len(e.terms) == 0 {
+		return false
+	}
+
+	doc := &document{words: words(text)}
+	for _, term := range e.terms {
+		if term.match(doc) {
+			return true
+		}
+	}
+
+	return false
+}
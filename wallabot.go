@@ -2,11 +2,13 @@ package wallabot
 
 import (
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"roob.re/wallabot/database"
 	"roob.re/wallabot/metrics"
 	"roob.re/wallabot/search"
 	"roob.re/wallabot/telegram"
 	"roob.re/wallabot/wallapop"
+	"time"
 )
 
 type Wallabot struct {
@@ -23,6 +25,13 @@ type Config struct {
 	DBPath               string
 	Token                string
 	MetricsListenAddress string
+	SearchMinInterval    time.Duration // Bounds for adaptive search intervals, defaults are used if zero
+	SearchMaxInterval    time.Duration
+	WallapopQPS          float64       // Requests per second made to Wallapop at most, the default is used if zero
+	CacheTTL             time.Duration // How long search pages are cached, caching is disabled if zero
+	CacheSize            int           // Maximum number of search pages cached, caching is disabled if zero
+	Proxies              []string      // HTTP or SOCKS5 proxies to rotate requests to Wallapop across
+	SentRetention        time.Duration // How long notified items are remembered once they stop showing up, forever if zero
 	telegram.WallabotConfig
 }
 
@@ -37,9 +46,29 @@ func New(c Config) (*Wallabot, error) {
 		return nil, fmt.Errorf("creating db: %w", err)
 	}
 
+	migrated, err := w.db.MigrateSentItems()
+	if err != nil {
+		return nil, fmt.Errorf("migrating sent items: %w", err)
+	}
+	if migrated > 0 {
+		log.WithFields(log.Fields{
+			"component": "database",
+		}).Infof("Moved sent items of %d users out of their records", migrated)
+	}
+
 	w.re = metrics.New()
 
 	w.wp = wallapop.New()
+	if c.WallapopQPS != 0 {
+		w.wp.SetRate(c.WallapopQPS)
+	}
+	err = w.wp.SetProxies(c.Proxies)
+	if err != nil {
+		return nil, fmt.Errorf("setting proxies: %w", err)
+	}
+	if c.CacheTTL != 0 {
+		w.wp.EnableCache(c.CacheTTL, c.CacheSize)
+	}
 
 	w.tg, err = telegram.NewWallabot(c.Token, w.db, w.wp, c.WallabotConfig)
 	if err != nil {
@@ -47,6 +76,13 @@ func New(c Config) (*Wallabot, error) {
 	}
 
 	w.se = search.New(w.db, w.wp, w.tg.Notify)
+	if c.SearchMinInterval != 0 {
+		w.se.MinInterval = c.SearchMinInterval
+	}
+	if c.SearchMaxInterval != 0 {
+		w.se.MaxInterval = c.SearchMaxInterval
+	}
+	w.se.SentRetention = c.SentRetention
 
 	return w, nil
 }
@@ -63,7 +99,7 @@ func (w *Wallabot) Start() error {
 	}()
 
 	go func() {
-		w.re.Watch(w.db, w.tg, w.se)
+		w.re.Watch(w.db, w.tg, w.se, w.wp)
 		eChan <- w.re.ListenAndServe(w.c.MetricsListenAddress)
 	}()
 
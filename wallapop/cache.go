@@ -0,0 +1,153 @@
+package wallapop
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-querystring/query"
+)
+
+// pageCache keeps recently fetched search pages, evicting the least recently used once full
+type pageCache struct {
+	ttl  time.Duration
+	size int
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Of *cachedPage, most recently used first
+
+	hits   uint64
+	misses uint64
+}
+
+type cachedPage struct {
+	key        string
+	items      []Item
+	pageParams string
+	empty      bool // The page had no results, which ends the search
+	stored     time.Time
+}
+
+func newPageCache(ttl time.Duration, size int) *pageCache {
+	return &pageCache{
+		ttl:     ttl,
+		size:    size,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Coordinates are rounded to this many degrees, about a kilometer, for searches from nearby places to share pages
+const cacheLocationQuantum = 0.01
+
+// pageKey identifies a page of results by the parameters sent to Wallapop, ignoring internal ones. Keywords are
+// normalized and coordinates rounded, so that equivalent searches share pages.
+func pageKey(args SearchArgs, pageParams string) (string, error) {
+	args.Keywords = strings.Join(strings.Fields(strings.ToLower(args.Keywords)), " ")
+	args.Latitude = math.Round(args.Latitude/cacheLocationQuantum) * cacheLocationQuantum
+	args.Longitude = math.Round(args.Longitude/cacheLocationQuantum) * cacheLocationQuantum
+
+	values, err := query.Values(args)
+	if err != nil {
+		return "", fmt.Errorf("marshalling url params: %w", err)
+	}
+
+	return values.Encode() + "|" + pageParams, nil
+}
+
+func (pc *pageCache) get(key string) (*cachedPage, bool) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+
+	elem, found := pc.entries[key]
+	if !found || time.Since(elem.Value.(*cachedPage).stored) > pc.ttl {
+		atomic.AddUint64(&pc.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&pc.hits, 1)
+	pc.lru.MoveToFront(elem)
+	return elem.Value.(*cachedPage), true
+}
+
+func (pc *pageCache) put(page *cachedPage) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+
+	if elem, found := pc.entries[page.key]; found {
+		elem.Value = page
+		pc.lru.MoveToFront(elem)
+		return
+	}
+
+	pc.entries[page.key] = pc.lru.PushFront(page)
+	for pc.lru.Len() > pc.size {
+		oldest := pc.lru.Back()
+		pc.lru.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*cachedPage).key)
+	}
+}
+
+// cachedSearchPage returns a page of results from the cache if enabled, or requests it and stores it otherwise
+func (c *Client) cachedSearchPage(args SearchArgs, pageParams string) ([]Item, string, error) {
+	if c.cache == nil {
+		return c.searchPage(args, pageParams)
+	}
+
+	key, err := pageKey(args, pageParams)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !args.Fresh {
+		if page, found := c.cache.get(key); found {
+			if page.empty {
+				return nil, "", errEmptyPage
+			}
+
+			return page.items, page.pageParams, nil
+		}
+	}
+
+	items, next, err := c.searchPage(args, pageParams)
+	if err != nil && err != errEmptyPage {
+		return nil, "", err
+	}
+
+	c.cache.put(&cachedPage{
+		key:        key,
+		items:      items,
+		pageParams: next,
+		empty:      err == errEmptyPage,
+		stored:     time.Now(),
+	})
+
+	return items, next, err
+}
+
+// EnableCache makes the client keep search pages for ttl, up to size pages, and serve them again instead of
+// requesting them to Wallapop. Searches with Fresh set always make requests. Caching stays disabled if either ttl or
+// size is not positive.
+func (c *Client) EnableCache(ttl time.Duration, size int) {
+	if ttl <= 0 || size <= 0 {
+		c.cache = nil
+		return
+	}
+
+	c.cache = newPageCache(ttl, size)
+}
+
+// CacheStats returns how many search pages were served from the cache and how many had to be requested.
+// Both are zero if the cache is not enabled.
+func (c *Client) CacheStats() (hits, misses uint64) {
+	if c.cache == nil {
+		return 0, 0
+	}
+
+	return atomic.LoadUint64(&c.cache.hits), atomic.LoadUint64(&c.cache.misses)
+}
@@ -0,0 +1,50 @@
+package wallapop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPageCache(t *testing.T) {
+	pc := newPageCache(time.Minute, 2)
+
+	pc.put(&cachedPage{key: "a", stored: time.Now()})
+	pc.put(&cachedPage{key: "b", stored: time.Now()})
+	if _, found := pc.get("a"); !found {
+		t.Fatal("Page a should be cached")
+	}
+
+	// b is now the least recently used
+	pc.put(&cachedPage{key: "c", stored: time.Now()})
+	if _, found := pc.get("b"); found {
+		t.Fatal("Page b should have been evicted")
+	}
+
+	pc.put(&cachedPage{key: "a", stored: time.Now().Add(-2 * time.Minute)})
+	if _, found := pc.get("a"); found {
+		t.Fatal("Page a should have expired")
+	}
+
+	if pc.hits != 1 || pc.misses != 2 {
+		t.Fatalf("Expected 1 hit and 2 misses, got %d and %d", pc.hits, pc.misses)
+	}
+}
+
+func TestPageKey_IgnoresInternalArgs(t *testing.T) {
+	plain, _ := pageKey(SearchArgs{Keywords: "rtx"}, "")
+	filtered, _ := pageKey(SearchArgs{Keywords: "rtx", NoZero: true, Fresh: true, Match: func(*Item) bool { return false }}, "")
+	if plain != filtered {
+		t.Fatalf("Keys differ: %q and %q", plain, filtered)
+	}
+
+	normalized, _ := pageKey(SearchArgs{Keywords: "  RTX ", Latitude: 41.3812, Longitude: 2.1734}, "")
+	nearby, _ := pageKey(SearchArgs{Keywords: "rtx", Latitude: 41.3788, Longitude: 2.1729}, "")
+	if normalized != nearby {
+		t.Fatalf("Keys differ: %q and %q", normalized, nearby)
+	}
+
+	next, _ := pageKey(SearchArgs{Keywords: "rtx"}, "start=40")
+	if plain == next {
+		t.Fatal("Keys for different pages should differ")
+	}
+}
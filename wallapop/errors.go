@@ -0,0 +1,154 @@
+package wallapop
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	wphttp "roob.re/wallabot/wallapop/http"
+)
+
+// ErrRateLimited is returned when Wallapop asks us to slow down
+type ErrRateLimited struct {
+	URL        string
+	RetryAfter time.Duration // Zero if Wallapop did not say
+}
+
+func (e *ErrRateLimited) Error() string {
+	if e.RetryAfter == 0 {
+		return fmt.Sprintf("rate limited by wallapop on %s", e.URL)
+	}
+
+	return fmt.Sprintf("rate limited by wallapop on %s, retry after %v", e.URL, e.RetryAfter)
+}
+
+// ErrForbidden is returned when Wallapop refuses to serve a request, usually because it is blocking us
+type ErrForbidden struct {
+	URL string
+}
+
+func (e *ErrForbidden) Error() string {
+	return fmt.Sprintf("forbidden by wallapop on %s", e.URL)
+}
+
+// ErrSignatureRejected is returned when Wallapop does not accept the signature of a request, which usually means the
+// signing key has changed
+type ErrSignatureRejected struct {
+	URL string
+}
+
+func (e *ErrSignatureRejected) Error() string {
+	return fmt.Sprintf("request signature rejected by wallapop on %s", e.URL)
+}
+
+// ErrUpstreamUnavailable is returned when Wallapop could not be reached or failed to answer, including when requests
+// are paused because it is blocking us
+type ErrUpstreamUnavailable struct {
+	URL        string
+	StatusCode int   // Zero if there was no response
+	Err        error // Reason there was no response, if any
+}
+
+func (e *ErrUpstreamUnavailable) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("wallapop unavailable on %s: %v", e.URL, e.Err)
+	}
+
+	return fmt.Sprintf("wallapop unavailable on %s: server responded with %d", e.URL, e.StatusCode)
+}
+
+func (e *ErrUpstreamUnavailable) Unwrap() error {
+	return e.Err
+}
+
+// ErrDecode is returned when a response from Wallapop cannot be understood
+type ErrDecode struct {
+	URL string
+	Err error
+}
+
+func (e *ErrDecode) Error() string {
+	return fmt.Sprintf("decoding response from wallapop on %s: %v", e.URL, e.Err)
+}
+
+func (e *ErrDecode) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKinds lists the kinds of errors counted by ErrorStats. Blocked requests were refused locally, while Wallapop
+// was blocking us, and never reached it.
+var ErrorKinds = []string{"rate_limited", "forbidden", "signature_rejected", "unavailable", "decode", "blocked"}
+
+type errorCounts struct {
+	rateLimited       uint64
+	forbidden         uint64
+	signatureRejected uint64
+	unavailable       uint64
+	decode            uint64
+	blocked           uint64
+}
+
+// count records an error by its kind, and returns it unchanged
+func (c *Client) count(err error) error {
+	switch err.(type) {
+	case *ErrRateLimited:
+		atomic.AddUint64(&c.errors.rateLimited, 1)
+	case *ErrForbidden:
+		atomic.AddUint64(&c.errors.forbidden, 1)
+	case *ErrSignatureRejected:
+		atomic.AddUint64(&c.errors.signatureRejected, 1)
+	case *ErrUpstreamUnavailable:
+		atomic.AddUint64(&c.errors.unavailable, 1)
+	case *ErrDecode:
+		atomic.AddUint64(&c.errors.decode, 1)
+	}
+
+	return err
+}
+
+// ErrorStats returns how many errors of each of ErrorKinds the client has run into
+func (c *Client) ErrorStats() map[string]uint64 {
+	return map[string]uint64{
+		"rate_limited":       atomic.LoadUint64(&c.errors.rateLimited),
+		"forbidden":          atomic.LoadUint64(&c.errors.forbidden),
+		"signature_rejected": atomic.LoadUint64(&c.errors.signatureRejected),
+		"unavailable":        atomic.LoadUint64(&c.errors.unavailable),
+		"decode":             atomic.LoadUint64(&c.errors.decode),
+		"blocked":            atomic.LoadUint64(&c.errors.blocked),
+	}
+}
+
+// requestError returns the error for a request that got no response
+func (c *Client) requestError(url string, err error) error {
+	unavailable := &ErrUpstreamUnavailable{URL: url, Err: err}
+	if errors.Is(err, wphttp.ErrBlocked) {
+		atomic.AddUint64(&c.errors.blocked, 1)
+		return unavailable
+	}
+
+	return c.count(unavailable)
+}
+
+// statusError returns the error for a response with a status other than 200
+func (c *Client) statusError(url string, response *http.Response) error {
+	switch code := response.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		retryAfter, _ := wphttp.RetryAfter(response.Header.Get("Retry-After"), time.Now())
+		return c.count(&ErrRateLimited{URL: url, RetryAfter: retryAfter})
+	case code == http.StatusUnauthorized:
+		return c.count(&ErrSignatureRejected{URL: url})
+	case code == http.StatusForbidden:
+		return c.count(&ErrForbidden{URL: url})
+	case code >= 500:
+		return c.count(&ErrUpstreamUnavailable{URL: url, StatusCode: code})
+	default:
+		return fmt.Errorf("server responded with %d to %s", code, url)
+	}
+}
+
+// decodeError returns the error for a response that could not be decoded
+func (c *Client) decodeError(url string, err error) error {
+	return c.count(&ErrDecode{URL: url, Err: err})
+}
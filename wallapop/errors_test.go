@@ -0,0 +1,48 @@
+package wallapop
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	wphttp "roob.re/wallabot/wallapop/http"
+)
+
+func TestClient_statusError(t *testing.T) {
+	c := &Client{}
+
+	rateLimited := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"60"}}}
+	var rerr *ErrRateLimited
+	if err := c.statusError("/search", rateLimited); !errors.As(err, &rerr) || rerr.RetryAfter != time.Minute {
+		t.Fatalf("Expected rate limited for a minute, got %v", err)
+	}
+
+	var serr *ErrSignatureRejected
+	if err := c.statusError("/search", &http.Response{StatusCode: http.StatusUnauthorized}); !errors.As(err, &serr) {
+		t.Fatalf("Expected signature rejected, got %v", err)
+	}
+
+	var uerr *ErrUpstreamUnavailable
+	if err := c.statusError("/search", &http.Response{StatusCode: http.StatusBadGateway}); !errors.As(err, &uerr) {
+		t.Fatalf("Expected unavailable, got %v", err)
+	}
+
+	stats := c.ErrorStats()
+	if stats["rate_limited"] != 1 || stats["signature_rejected"] != 1 || stats["unavailable"] != 1 || stats["forbidden"] != 0 {
+		t.Fatalf("Unexpected error counts %v", stats)
+	}
+}
+
+func TestClient_requestError_Blocked(t *testing.T) {
+	c := &Client{}
+
+	var uerr *ErrUpstreamUnavailable
+	if err := c.requestError("/search", wphttp.ErrBlocked); !errors.As(err, &uerr) || !errors.Is(err, wphttp.ErrBlocked) {
+		t.Fatalf("Expected unavailable because of being blocked, got %v", err)
+	}
+
+	if stats := c.ErrorStats(); stats["blocked"] != 1 || stats["unavailable"] != 0 {
+		t.Fatalf("Expected requests refused locally not to count as unavailable, got %v", stats)
+	}
+}
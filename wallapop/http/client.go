@@ -7,10 +7,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/google/go-querystring/query"
-	"github.com/sethgrid/pester"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"time"
@@ -24,27 +22,24 @@ const timestampHeader = "Timestamp"
 
 const baseURLv3 = "https://api.wallapop.com/api/v3"
 
-func New() *Client {
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		panic(fmt.Errorf("creating cookie jar: %w", err))
-	}
-
-	pt := pester.New()
-	pt.MaxRetries = 3
-	pt.Backoff = pester.ExponentialJitterBackoff
-	pt.Timeout = 7 * time.Second
-	pt.Jar = jar
+// Searches being forbidden means we are blocked, unlike single items
+const searchEndpoint = "/general/search"
 
+func New() *Client {
 	return &Client{
-		client: pt,
-		Key:    defaultKey,
+		routes:  []*route{newRoute(nil)},
+		Key:     defaultKey,
+		Limiter: NewLimiter(defaultQPS),
 	}
 }
 
 type Client struct {
-	client *pester.Client
+	routes []*route // Requests rotate across these, a single direct route unless proxies are set
+	next   uint32
 	Key    string
+
+	// Limiter throttles all requests made through the client
+	Limiter *Limiter
 }
 
 func (c *Client) Request(endpoint string, method string, params interface{}) (*http.Response, error) {
@@ -64,20 +59,72 @@ func (c *Client) Request(endpoint string, method string, params interface{}) (*h
 	}
 	u.RawQuery = urlArgs.Encode()
 
-	log.Printf("Requesting %v...", u.String())
-	req := &http.Request{
-		Method: method,
-		URL:    u,
-		Header: http.Header{},
+	search := strings.HasPrefix(endpoint, searchEndpoint)
+	for attempt := 1; ; attempt++ {
+		// Wait before building the request, as it is signed with the current time
+		err = c.Limiter.Wait()
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Requesting %v...", u.String())
+		req := &http.Request{
+			Method: method,
+			URL:    u,
+			Header: http.Header{},
+		}
+		c.addStandardHeaders(req)
+
+		err = c.sign(req)
+		if err != nil {
+			return nil, fmt.Errorf("signing request: %w", err)
+		}
+
+		r, err := c.nextRoute()
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := r.client.Do(req)
+
+		// A proxy being blocked is not a reason to stop, as long as there are others to rotate to. Only the response
+		// finally returned counts towards backing off.
+		blocked := r.observe(response, err)
+		if blocked && attempt < len(c.routes) && c.anyHealthy() {
+			_ = response.Body.Close()
+			log.WithFields(log.Fields{
+				"component": "wallapop",
+			}).Warnf("Proxy %s got %d from %s, retrying through the next one", r.name(), response.StatusCode, endpoint)
+			continue
+		}
+
+		if err == nil {
+			c.Limiter.Observe(response, search)
+		}
+
+		return response, err
+	}
+}
+
+// Fetch gets a URL outside the API, such as a picture from the CDN, through the same limiter and routes as API
+// requests. It is not signed, and its response does not count towards backing off.
+func (c *Client) Fetch(rawURL string) (*http.Response, error) {
+	err := c.Limiter.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
 	}
-	c.addStandardHeaders(req)
 
-	err = c.sign(req)
+	r, err := c.nextRoute()
 	if err != nil {
-		return nil, fmt.Errorf("signing request: %w", err)
+		return nil, err
 	}
 
-	return c.client.Do(req)
+	return r.client.Do(req)
 }
 
 func (c *Client) addStandardHeaders(req *http.Request) {
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBlocked is returned without making any request while Wallapop is blocking us
+var ErrBlocked = errors.New("wallapop is blocking requests, paused")
+
+const (
+	// Close to the cadence of two workers sleeping 10 to 15 seconds between requests, which Wallapop has tolerated
+	defaultQPS   = 0.15
+	defaultBurst = 2
+
+	// Backoff after a throttled request that did not say how long to wait
+	backoffBase = 30 * time.Second
+	backoffMax  = 30 * time.Minute
+
+	// After this many throttled requests in a row, requests are not even attempted for a while
+	breakerThreshold   = 3
+	breakerCooldown    = 10 * time.Minute
+	breakerCooldownMax = 2 * time.Hour
+)
+
+// Limiter is a token bucket shared by every request made to Wallapop, which also backs off when Wallapop throttles
+// us and acts as a circuit breaker when it blocks us
+type Limiter struct {
+	mtx sync.Mutex
+
+	qps    float64
+	tokens float64
+	last   time.Time
+
+	pausedUntil time.Time // Set when Wallapop asks us to slow down
+	throttled   int       // Consecutive responses throttling our requests, or forbidding our searches
+	openUntil   time.Time // Requests fail straight away until then
+	cooldown    time.Duration
+}
+
+func NewLimiter(qps float64) *Limiter {
+	return &Limiter{
+		qps:      qps,
+		tokens:   defaultBurst,
+		last:     time.Now(),
+		cooldown: breakerCooldown,
+	}
+}
+
+// SetQPS changes the sustained number of requests per second allowed
+func (l *Limiter) SetQPS(qps float64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.qps = qps
+}
+
+// Blocked returns whether requests are being refused because Wallapop is blocking us, and until when
+func (l *Limiter) Blocked() (bool, time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return time.Now().Before(l.openUntil), l.openUntil
+}
+
+// Wait blocks until a request can be made, or returns ErrBlocked if requests are paused
+func (l *Limiter) Wait() error {
+	for {
+		l.mtx.Lock()
+		now := time.Now()
+
+		if now.Before(l.openUntil) {
+			l.mtx.Unlock()
+			return ErrBlocked
+		}
+
+		if now.Before(l.pausedUntil) {
+			wait := l.pausedUntil.Sub(now)
+			l.mtx.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+
+		l.tokens += now.Sub(l.last).Seconds() * l.qps
+		if l.tokens > defaultBurst {
+			l.tokens = defaultBurst
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mtx.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.qps * float64(time.Second))
+		l.mtx.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// Observe updates the backoff and circuit breaker state with the response to a request. Only searches being forbidden
+// count as throttling, as single items can be forbidden for reasons of their own.
+func (l *Limiter) Observe(response *http.Response, search bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+	case response.StatusCode == http.StatusForbidden && search:
+	case response.StatusCode == http.StatusForbidden:
+		return
+	default:
+		if l.throttled >= breakerThreshold {
+			log.WithFields(log.Fields{
+				"component": "wallapop",
+			}).Infof("Wallapop is answering again, resuming requests")
+		}
+
+		l.throttled = 0
+		l.cooldown = breakerCooldown
+		return
+	}
+
+	l.throttled++
+	now := time.Now()
+
+	wait, ok := RetryAfter(response.Header.Get("Retry-After"), now)
+	if !ok {
+		wait = backoffBase << (l.throttled - 1)
+		if wait > backoffMax || wait <= 0 {
+			wait = backoffMax
+		}
+	}
+	l.pausedUntil = now.Add(wait)
+
+	log.WithFields(log.Fields{
+		"component": "wallapop",
+	}).Warnf("Wallapop responded with %d, backing off for %v", response.StatusCode, wait)
+
+	if l.throttled < breakerThreshold {
+		return
+	}
+
+	l.openUntil = now.Add(l.cooldown)
+	log.WithFields(log.Fields{
+		"component": "wallapop",
+	}).Errorf("Wallapop is blocking us, pausing all requests until %s", l.openUntil.Format(time.RFC3339))
+
+	// If the first request after the pause is refused too, wait longer next time
+	l.cooldown *= 2
+	if l.cooldown > breakerCooldownMax {
+		l.cooldown = breakerCooldownMax
+	}
+}
+
+// RetryAfter parses a Retry-After header, which can be either a number of seconds or a date
+func RetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		return t.Sub(now), true
+	}
+
+	return 0, false
+}
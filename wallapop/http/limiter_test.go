@@ -0,0 +1,66 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Breaker(t *testing.T) {
+	l := NewLimiter(100)
+
+	throttled := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"0"}}}
+	for i := 0; i < breakerThreshold-1; i++ {
+		l.Observe(throttled, false)
+		if blocked, _ := l.Blocked(); blocked {
+			t.Fatalf("Blocked after %d throttled responses", i+1)
+		}
+	}
+
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Expected to be allowed to retry, got %v", err)
+	}
+
+	l.Observe(throttled, false)
+	if blocked, _ := l.Blocked(); !blocked {
+		t.Fatalf("Not blocked after %d throttled responses", breakerThreshold)
+	}
+
+	if err := l.Wait(); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("Expected ErrBlocked, got %v", err)
+	}
+}
+
+func TestLimiter_ItemForbidden(t *testing.T) {
+	l := NewLimiter(100)
+
+	forbidden := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	for i := 0; i < breakerThreshold; i++ {
+		l.Observe(forbidden, false)
+	}
+
+	if blocked, _ := l.Blocked(); blocked {
+		t.Fatalf("Blocked after forbidden item requests")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{header: "", ok: false},
+		{header: "120", expected: 2 * time.Minute, ok: true},
+		{header: "Thu, 01 Jul 2021 12:05:00 GMT", expected: 5 * time.Minute, ok: true},
+		{header: "soon", ok: false},
+	} {
+		actual, ok := RetryAfter(tc.header, now)
+		if ok != tc.ok || actual != tc.expected {
+			t.Fatalf("Retry-After %q: expected %v (%v), got %v (%v)", tc.header, tc.expected, tc.ok, actual, ok)
+		}
+	}
+}
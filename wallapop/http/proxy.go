@@ -0,0 +1,213 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethgrid/pester"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoHealthyProxy is returned when proxies are configured but all of them are failing
+var ErrNoHealthyProxy = errors.New("no healthy proxy available")
+
+const (
+	// A proxy is taken out of rotation after this many failed requests in a row
+	proxyMaxFailures = 3
+	// Unhealthy proxies are checked this often to put them back in rotation
+	proxyCheckInterval = 5 * time.Minute
+
+	proxyCheckURL = "https://es.wallapop.com/"
+)
+
+// route is a way to reach Wallapop, either directly or through a proxy, with its own cookies
+type route struct {
+	proxy  *url.URL // Nil for direct connections
+	client *pester.Client
+
+	mtx      sync.Mutex
+	failures int
+	healthy  bool
+
+	requests uint64
+	errors   uint64
+}
+
+// ProxyStats describes the state of one of the routes requests can take
+type ProxyStats struct {
+	Proxy    string // Empty for direct connections
+	Healthy  bool
+	Requests uint64
+	Errors   uint64
+}
+
+func newRoute(proxy *url.URL) *route {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(fmt.Errorf("creating cookie jar: %w", err))
+	}
+
+	pt := pester.New()
+	// Retrying is left to the limiter and the callers, so every request made waits for its turn and is signed anew
+	pt.MaxRetries = 1
+	pt.Timeout = 7 * time.Second
+	pt.Jar = jar
+	if proxy != nil {
+		// Both http and socks5 schemes are supported by the standard transport, which keeps its timeouts and pooling
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		pt.Transport = transport
+	}
+
+	return &route{
+		proxy:   proxy,
+		client:  pt,
+		healthy: true,
+	}
+}
+
+func (r *route) name() string {
+	if r.proxy == nil {
+		return ""
+	}
+
+	// Do not leak credentials to logs or metrics
+	return r.proxy.Redacted()
+}
+
+func (r *route) isHealthy() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.healthy
+}
+
+// observe records the outcome of a request, and returns whether it looks like Wallapop is blocking the route
+func (r *route) observe(response *http.Response, err error) bool {
+	atomic.AddUint64(&r.requests, 1)
+
+	blocked := err == nil &&
+		(response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusTooManyRequests)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if err == nil && !blocked {
+		r.failures = 0
+		return false
+	}
+
+	atomic.AddUint64(&r.errors, 1)
+	r.failures++
+	if r.healthy && r.failures >= proxyMaxFailures && r.proxy != nil {
+		r.healthy = false
+		log.WithFields(log.Fields{
+			"component": "wallapop",
+		}).Warnf("Proxy %s failed %d times in a row, taking it out of rotation", r.name(), r.failures)
+	}
+
+	return blocked
+}
+
+// SetProxies makes the client spread requests across the given proxies, given as http:// or socks5:// URLs, instead
+// of connecting directly. Proxies are checked periodically while they are failing.
+func (c *Client) SetProxies(proxies []string) error {
+	if len(proxies) == 0 {
+		return nil
+	}
+
+	routes := make([]*route, 0, len(proxies))
+	for _, raw := range proxies {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("parsing proxy %q: %w", raw, err)
+		}
+
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("proxy %q has unsupported scheme %q", u.Redacted(), u.Scheme)
+		}
+
+		routes = append(routes, newRoute(u))
+	}
+
+	c.routes = routes
+	go c.checkProxies()
+
+	return nil
+}
+
+// nextRoute returns the next healthy route in rotation
+func (c *Client) nextRoute() (*route, error) {
+	for i := 0; i < len(c.routes); i++ {
+		r := c.routes[atomic.AddUint32(&c.next, 1)%uint32(len(c.routes))]
+		if r.isHealthy() {
+			return r, nil
+		}
+	}
+
+	return nil, ErrNoHealthyProxy
+}
+
+// anyHealthy returns whether there is any healthy route left
+func (c *Client) anyHealthy() bool {
+	for _, r := range c.routes {
+		if r.isHealthy() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkProxies periodically puts back in rotation unhealthy proxies that can reach Wallapop again
+func (c *Client) checkProxies() {
+	for {
+		time.Sleep(proxyCheckInterval)
+
+		for _, r := range c.routes {
+			if r.isHealthy() {
+				continue
+			}
+
+			response, err := r.client.Get(proxyCheckURL)
+			if err == nil {
+				_ = response.Body.Close()
+			}
+			if err != nil || response.StatusCode >= 400 {
+				continue
+			}
+
+			r.mtx.Lock()
+			r.healthy = true
+			r.failures = 0
+			r.mtx.Unlock()
+
+			log.WithFields(log.Fields{
+				"component": "wallapop",
+			}).Infof("Proxy %s is working again, putting it back in rotation", r.name())
+		}
+	}
+}
+
+// ProxyStats returns the state of every route requests can take
+func (c *Client) ProxyStats() []ProxyStats {
+	stats := make([]ProxyStats, 0, len(c.routes))
+	for _, r := range c.routes {
+		stats = append(stats, ProxyStats{
+			Proxy:    r.name(),
+			Healthy:  r.isHealthy(),
+			Requests: atomic.LoadUint64(&r.requests),
+			Errors:   atomic.LoadUint64(&r.errors),
+		})
+	}
+
+	return stats
+}
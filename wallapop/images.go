@@ -0,0 +1,37 @@
+package wallapop
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register decoders for the formats Wallapop serves
+	_ "image/png"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Image downloads and decodes an item image. Images are served from a CDN, but are still fetched through the limiter
+// and proxies so they do not add to what Wallapop sees from us. Their errors are not those of the API, so they are
+// neither typed nor counted.
+func (c *Client) Image(url string) (image.Image, error) {
+	response, err := c.http.Fetch(url)
+	if err != nil {
+		return nil, fmt.Errorf("requesting image %s: %w", url, err)
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Warnf("error closing body: %v", err)
+		}
+	}()
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("server responded with %d to %s", response.StatusCode, url)
+	}
+
+	img, _, err := image.Decode(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image %s: %w", url, err)
+	}
+
+	return img, nil
+}
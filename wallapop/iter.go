@@ -0,0 +1,78 @@
+package wallapop
+
+// SearchIter iterates over the results of a search, fetching pages as they are needed:
+//
+//	it := client.SearchIter(args)
+//	for it.Next() {
+//		item := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type SearchIter struct {
+	c    *Client
+	args SearchArgs
+
+	page       []Item // Results of the current page that pass the filters
+	pos        int    // Index in page of the next result
+	pageParams string // Returned by Wallapop API, collection of GET params that can be used to fetch the next page
+	pages      int    // Pages fetched so far
+	returned   int    // Results returned so far
+	last       bool   // No more pages should be fetched
+
+	item *Item
+	err  error
+}
+
+// SearchIter returns an iterator over the results of a search. No request is made until Next is called.
+func (c *Client) SearchIter(args SearchArgs) *SearchIter {
+	return &SearchIter{
+		c:    c,
+		args: args.WithDefaults(),
+	}
+}
+
+// Next advances to the next result, fetching the next page if needed. It returns false when there are no more
+// results, the page or result limits are reached, or an error happened.
+func (it *SearchIter) Next() bool {
+	it.item = nil
+
+	if it.err != nil || (it.args.Limit != 0 && it.returned >= it.args.Limit) {
+		return false
+	}
+
+	for it.pos >= len(it.page) {
+		if it.last || it.pages >= it.args.Pages {
+			return false
+		}
+
+		raw, pageParams, err := it.c.cachedSearchPage(it.args, it.pageParams)
+		it.pages++
+		if err != nil && err != errEmptyPage {
+			it.err = err
+			return false
+		}
+
+		it.page = it.args.Filter(raw)
+		it.pos = 0
+		it.pageParams = pageParams
+
+		// Results come newest first, so the following pages will not have anything new either
+		it.last = err == errEmptyPage || (it.args.Known != nil && it.args.allKnown(raw))
+	}
+
+	it.item = &it.page[it.pos]
+	it.pos++
+	it.returned++
+
+	return true
+}
+
+// Item returns the current result. It is only valid after Next has returned true.
+func (it *SearchIter) Item() *Item {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *SearchIter) Err() error {
+	return it.err
+}
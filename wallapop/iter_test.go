@@ -0,0 +1,73 @@
+package wallapop
+
+import (
+	"testing"
+	"time"
+)
+
+// cachedClient returns a client that serves the given pages of results for args from its cache, without requests
+func cachedClient(t *testing.T, args SearchArgs, pages ...[]Item) *Client {
+	c := &Client{cache: newPageCache(time.Hour, 16)}
+
+	pageParams := ""
+	for i, items := range pages {
+		key, err := pageKey(args, pageParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pageParams = string(rune('a' + i))
+		c.cache.put(&cachedPage{key: key, items: items, pageParams: pageParams, stored: time.Now()})
+	}
+
+	key, _ := pageKey(args, pageParams)
+	c.cache.put(&cachedPage{key: key, empty: true, stored: time.Now()})
+
+	return c
+}
+
+func TestSearchIter(t *testing.T) {
+	args := SearchArgs{Keywords: "rtx"}
+	c := cachedClient(t, args,
+		[]Item{{ID: "1"}, {ID: "2", Price: 10}},
+		[]Item{{ID: "3"}, {ID: "4", Price: 20}},
+		[]Item{{ID: "5"}},
+	)
+
+	for _, tc := range []struct {
+		name     string
+		args     SearchArgs
+		expected []string
+	}{
+		{name: "all", args: args, expected: []string{"1", "2", "3", "4", "5"}},
+		{name: "pages", args: SearchArgs{Keywords: "rtx", Pages: 2}, expected: []string{"1", "2", "3", "4"}},
+		{name: "limit", args: SearchArgs{Keywords: "rtx", Limit: 3}, expected: []string{"1", "2", "3"}},
+		{name: "filtered", args: SearchArgs{Keywords: "rtx", NoZero: true}, expected: []string{"2", "4"}},
+		{
+			name: "known",
+			args: SearchArgs{Keywords: "rtx", Known: func(item *Item) bool {
+				return item.ID == "3" || item.ID == "4"
+			}},
+			expected: []string{"1", "2", "3", "4"},
+		},
+	} {
+		var actual []string
+		it := c.SearchIter(tc.args)
+		for it.Next() {
+			actual = append(actual, it.Item().ID)
+		}
+
+		if it.Err() != nil {
+			t.Fatalf("%s: %v", tc.name, it.Err())
+		}
+
+		if len(actual) != len(tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+		for i := range actual {
+			if actual[i] != tc.expected[i] {
+				t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+			}
+		}
+	}
+}
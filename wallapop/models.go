@@ -2,8 +2,10 @@ package wallapop
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type searchResponse struct {
@@ -27,10 +29,13 @@ type SearchArgs struct {
 	Exchange  bool    `url:"exchange,omitempty"`
 
 	// Internal parameters, not passed down to Wallapop API
-	Strict bool `url:"-"` // If true, wallabot will not filter out results not containing any of the keywords in the title.
-	NoZero bool `url:"-"` // If true, wallabot will ignore results with a prize of 0€
+	Match  func(item *Item) bool `url:"-"` // If set, wallabot will filter out results for which Match returns false.
+	NoZero bool                  `url:"-"` // If true, wallabot will ignore results with a prize of 0€
+	Fresh  bool                  `url:"-"` // If true, results are always requested rather than taken from the cache
+	Known  func(item *Item) bool `url:"-"` // If set, paging stops after a page where every item is known
 
-	pages int `url:"-"`
+	Pages int `url:"-"` // Maximum number of pages fetched, searchPagesDefault if zero
+	Limit int `url:"-"` // Maximum number of results returned, unlimited if zero
 }
 
 type Item struct {
@@ -45,10 +50,91 @@ type Item struct {
 	Currency string  `json:"currency"`
 
 	Slug string `json:"web_slug"`
+
+	Flags ItemFlags `json:"flags"`
+
+	Seller ItemSeller `json:"user"`
+}
+
+type ItemSeller struct {
+	ID        string `json:"id"`
+	MicroName string `json:"micro_name"`
+	Slug      string `json:"web_slug"`
+}
+
+type ItemFlags struct {
+	Pending  bool `json:"pending"`
+	Sold     bool `json:"sold"`
+	Reserved bool `json:"reserved"`
+	Banned   bool `json:"banned"`
+	Expired  bool `json:"expired"`
+	OnHold   bool `json:"onhold"`
+}
+
+// itemResponse is the response of the item detail endpoint, which nests some fields differently than search results
+type itemResponse struct {
+	ID    string `json:"id"`
+	Title struct {
+		Original string `json:"original"`
+	} `json:"title"`
+	Description struct {
+		Original string `json:"original"`
+	} `json:"description"`
+	Price struct {
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+	} `json:"price"`
+	Slug   string    `json:"slug"`
+	Flags  ItemFlags `json:"flags"`
+	UserID string    `json:"user_id"`
+}
+
+func (ir *itemResponse) Item() *Item {
+	return &Item{
+		ID:          ir.ID,
+		Title:       ir.Title.Original,
+		Description: ir.Description.Original,
+		Price:       ir.Price.Amount,
+		Currency:    ir.Price.Currency,
+		Slug:        ir.Slug,
+		Flags:       ir.Flags,
+		Seller:      ItemSeller{ID: ir.UserID},
+	}
 }
 
 type ItemImage struct {
 	OriginalURL string `json:"original"`
+	SmallURL    string `json:"small"`
+}
+
+// Thumbnail returns the URL of the smallest available version of the image
+func (ii ItemImage) Thumbnail() string {
+	if ii.SmallURL != "" {
+		return ii.SmallURL
+	}
+
+	return ii.OriginalURL
+}
+
+// Seller is the public profile of a seller
+type Seller struct {
+	ID         string
+	Name       string
+	Registered time.Time
+}
+
+type userResponse struct {
+	ID           string `json:"id"`
+	MicroName    string `json:"micro_name"`
+	RegisterDate int64  `json:"register_date"` // Milliseconds since epoch
+}
+
+func (ur *userResponse) Seller() *Seller {
+	return &Seller{
+		ID:         ur.ID,
+		Name:       ur.MicroName,
+		Registered: time.Unix(0, ur.RegisterDate*int64(time.Millisecond)),
+	}
 }
 
 // Special characters in markdown
@@ -58,12 +144,26 @@ func markdownEscape(source string) string {
 	return mdSpecial.ReplaceAllString(source, `\$0`)
 }
 
+// Inside the URL part of a markdown link only these need escaping
+var urlEscaper = strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+
 func replaceCurrency(source string) string {
 	return strings.NewReplacer("EUR", "€", "USD", "$").Replace(source)
 }
 
+const wpLinkBase = "https://es.wallapop.com/item"
+
+// Available returns false if the item has been sold, reserved or otherwise taken down
+func (i *Item) Available() bool {
+	f := i.Flags
+	return !f.Sold && !f.Reserved && !f.Banned && !f.Expired && !f.OnHold
+}
+
+func (i *Item) URL() string {
+	return wpLinkBase + "/" + i.Slug
+}
+
 func (i *Item) Markdown() string {
-	const wpLinkBase = "https://es.wallapop.com/item"
 	return fmt.Sprintf(
 		"*%s*\n"+
 			"*%d%s*\n"+
@@ -74,3 +174,67 @@ func (i *Item) Markdown() string {
 		markdownEscape(wpLinkBase), markdownEscape(i.Slug),
 	)
 }
+
+// MarkdownLine returns a single-line summary of the item, suitable for lists of items
+func (i *Item) MarkdownLine() string {
+	return fmt.Sprintf(
+		"[%s](%s) *%d%s*",
+		markdownEscape(i.Title), urlEscaper.Replace(i.URL()), int(i.Price), replaceCurrency(i.Currency),
+	)
+}
+
+// MarkdownPriceDrop returns a line describing how much the price of the item has dropped from previous
+func (i *Item) MarkdownPriceDrop(previous float64) string {
+	currency := replaceCurrency(i.Currency)
+	percent := 0
+	if previous > 0 {
+		percent = int(math.Round((previous - i.Price) / previous * 100))
+	}
+
+	return markdownEscape(fmt.Sprintf(
+		"📉 was %d%s, now %d%s (-%d%%)",
+		int(previous), currency, int(i.Price), currency, percent,
+	))
+}
+
+// MarkdownWarnings returns a line warning that the item might be a scam, and why
+func (i *Item) MarkdownWarnings(reasons []string) string {
+	return markdownEscape("⚠️ possible scam: " + strings.Join(reasons, ", "))
+}
+
+// MarkdownRepost returns a line telling that the item looks like a re-listing of one first seen at firstSeen
+func (i *Item) MarkdownRepost(firstSeen time.Time) string {
+	var ago string
+	switch days := int(time.Since(firstSeen).Hours() / 24); days {
+	case 0:
+		ago = "today"
+	case 1:
+		ago = "yesterday"
+	default:
+		ago = fmt.Sprintf("%d days ago", days)
+	}
+
+	return markdownEscape(fmt.Sprintf("🔁 reposted (first seen %s)", ago))
+}
+
+// MarkdownDeal returns a line telling how much cheaper the item is than usual, given as a fraction
+func (i *Item) MarkdownDeal(belowUsual float64) string {
+	return markdownEscape(fmt.Sprintf("💰 %d%% below the usual price for this search", int(math.Round(belowUsual*100))))
+}
+
+// MarkdownLowest returns a line with the lowest price an item has been seen at
+func (i *Item) MarkdownLowest(lowest float64) string {
+	return markdownEscape(fmt.Sprintf("📊 lowest seen: %d%s", int(lowest), replaceCurrency(i.Currency)))
+}
+
+// MarkdownGone returns a message telling that the item is no longer available, with status being the reason
+func (i *Item) MarkdownGone(status string) string {
+	if i.Title == "" {
+		return markdownEscape(fmt.Sprintf("🚫 An item you were notified about has been %s", status))
+	}
+
+	return fmt.Sprintf(
+		"🚫 *%s* has been %s\n%s",
+		markdownEscape(i.Title), markdownEscape(status), markdownEscape(i.URL()),
+	)
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	wphttp "roob.re/wallabot/wallapop/http"
@@ -14,8 +14,18 @@ const searchPagesDefault = 8
 
 var errEmptyPage = fmt.Errorf("search results empty")
 
+// ErrItemNotFound is returned by Item when the item does not exist anymore
+var ErrItemNotFound = fmt.Errorf("item not found")
+
+// ErrSellerNotFound is returned by Seller when the seller does not exist anymore
+var ErrSellerNotFound = fmt.Errorf("seller not found")
+
+var errNotFound = fmt.Errorf("not found")
+
 type Client struct {
-	http *wphttp.Client
+	http   *wphttp.Client
+	cache  *pageCache // Nil unless enabled
+	errors errorCounts
 }
 
 func New() *Client {
@@ -24,47 +34,77 @@ func New() *Client {
 	}
 }
 
+// SetRate sets how many requests per second are made to Wallapop at most, across all users of the client
+func (c *Client) SetRate(qps float64) {
+	c.http.Limiter.SetQPS(qps)
+}
+
+// SetProxies makes requests rotate across the given http:// or socks5:// proxies
+func (c *Client) SetProxies(proxies []string) error {
+	return c.http.SetProxies(proxies)
+}
+
+// ProxyStats returns the health and usage of each proxy, or of the direct connection if there are none
+func (c *Client) ProxyStats() []wphttp.ProxyStats {
+	return c.http.ProxyStats()
+}
+
+// Blocked returns whether requests are paused because Wallapop is blocking us, and until when
+func (c *Client) Blocked() (bool, time.Time) {
+	return c.http.Limiter.Blocked()
+}
+
 func (sa SearchArgs) WithDefaults() SearchArgs {
-	if sa.pages == 0 {
-		sa.pages = searchPagesDefault
+	if sa.Pages == 0 {
+		sa.Pages = searchPagesDefault
 	}
 
 	return sa
 }
 
-func (c *Client) Search(args SearchArgs) ([]Item, error) {
-	args = args.WithDefaults()
-
-	var items []Item
+// Passes returns whether an item passes the internal filters of the search, Match and NoZero
+func (sa SearchArgs) Passes(item *Item) bool {
+	if sa.Match != nil && !sa.Match(item) {
+		return false
+	}
 
-	var pageItems []Item
-	var pageParams string // Returned by Wallapop API, collection of GET params that can be used to fetch the next page
-	var err error
+	return !sa.NoZero || item.Price != 0
+}
 
-	for page := 0; page < args.pages; page++ {
-		pageItems, pageParams, err = c.searchPage(args, pageParams)
-		if err != nil && err != errEmptyPage {
-			return items, err
+// Filter returns the items that pass the internal filters of the search
+func (sa SearchArgs) Filter(items []Item) []Item {
+	var filtered []Item
+	for i := range items {
+		if sa.Passes(&items[i]) {
+			filtered = append(filtered, items[i])
 		}
+	}
 
-		for _, item := range pageItems {
-			if args.Strict && !containsAny(item.Title, strings.Fields(args.Keywords)) {
-				continue
-			}
-
-			if args.NoZero && item.Price == 0 {
-				continue
-			}
+	return filtered
+}
 
-			items = append(items, item)
+// allKnown returns whether every item on a page that passes the filters of the search is known to it
+func (sa SearchArgs) allKnown(items []Item) bool {
+	for i := range items {
+		if sa.Passes(&items[i]) && !sa.Known(&items[i]) {
+			return false
 		}
+	}
 
-		if err == errEmptyPage {
-			break
-		}
+	return true
+}
+
+// Search returns all the results of a search, up to the page and result limits in args.
+// Results found before an error are returned along with it.
+func (c *Client) Search(args SearchArgs) ([]Item, error) {
+	var items []Item
+
+	it := c.SearchIter(args)
+	for it.Next() {
+		items = append(items, *it.Item())
 	}
 
-	return items, nil
+	return items, it.Err()
 }
 
 func (c *Client) searchPage(args SearchArgs, pageParams string) ([]Item, string, error) {
@@ -74,7 +114,7 @@ func (c *Client) searchPage(args SearchArgs, pageParams string) ([]Item, string,
 	url := searchPath + "?" + pageParams
 	response, err := c.http.Request(url, http.MethodGet, args)
 	if err != nil {
-		return nil, "", fmt.Errorf("could not make http request: %w", err)
+		return nil, "", c.requestError(url, err)
 	}
 	defer func() {
 		err := response.Body.Close()
@@ -84,13 +124,13 @@ func (c *Client) searchPage(args SearchArgs, pageParams string) ([]Item, string,
 	}()
 
 	if response.StatusCode != 200 {
-		return nil, "", fmt.Errorf("server responded with %d to %s", response.StatusCode, url)
+		return nil, "", c.statusError(url, response)
 	}
 
 	sr := &searchResponse{}
 	err = json.NewDecoder(response.Body).Decode(sr)
 	if err != nil {
-		return nil, "", fmt.Errorf("decoding http response: %w", err)
+		return nil, "", c.decodeError(url, err)
 	}
 
 	if len(sr.Items) == 0 {
@@ -102,13 +142,63 @@ func (c *Client) searchPage(args SearchArgs, pageParams string) ([]Item, string,
 	return sr.Items, pageParams, nil
 }
 
-func containsAny(haystack string, needles []string) bool {
-	haystack = strings.ToLower(haystack)
-	for _, needle := range needles {
-		if strings.Contains(haystack, strings.ToLower(needle)) {
-			return true
+// Item fetches the current details of an item, including whether it is still available
+func (c *Client) Item(id string) (*Item, error) {
+	const itemPath = "/items/"
+
+	ir := &itemResponse{}
+	err := c.get(itemPath+id, ir)
+	if err == errNotFound {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ir.Item(), nil
+}
+
+// Seller fetches the public profile of a seller
+func (c *Client) Seller(id string) (*Seller, error) {
+	const userPath = "/users/"
+
+	ur := &userResponse{}
+	err := c.get(userPath+id, ur)
+	if err == errNotFound {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ur.Seller(), nil
+}
+
+// get requests a single object from the API and decodes it into v
+func (c *Client) get(url string, v interface{}) error {
+	response, err := c.http.Request(url, http.MethodGet, struct{}{})
+	if err != nil {
+		return c.requestError(url, err)
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Warnf("error closing body: %v", err)
 		}
+	}()
+
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+		return errNotFound
+	}
+
+	if response.StatusCode != 200 {
+		return c.statusError(url, response)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(v)
+	if err != nil {
+		return c.decodeError(url, err)
 	}
 
-	return false
+	return nil
 }